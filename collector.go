@@ -3,17 +3,20 @@
 package main
 
 import (
+	"math"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
-	mon "github.com/digineo/go-ping/monitor"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/czerwonk/ping_exporter/config"
 )
 
 type pingCollector struct {
-	monitor                 *mon.Monitor
+	monitors                *monitorSet
 	enableDeprecatedMetrics bool
 	rttUnit                 rttUnit
 
@@ -21,35 +24,77 @@ type pingCollector struct {
 
 	mutex sync.RWMutex
 
-	customLabels *customLabelSet
-	metrics      map[string]*mon.Metrics
+	customLabels  *customLabelSet
+	targetConfigs map[string]config.TargetConfig // by targetConfigKey
+	stats         *targetStatsSet
 
-	rttDesc    scaledMetrics
-	bestDesc   scaledMetrics
-	worstDesc  scaledMetrics
-	meanDesc   scaledMetrics
-	stddevDesc scaledMetrics
-	lossDesc   *prometheus.Desc
-	progDesc   *prometheus.Desc
+	rttDesc             scaledMetrics
+	bestDesc            scaledMetrics
+	worstDesc           scaledMetrics
+	meanDesc            scaledMetrics
+	stddevDesc          scaledMetrics
+	quantileDesc        scaledMetrics
+	jitterDesc          scaledMetrics
+	rttHistDesc         *prometheus.Desc
+	lossDesc            *prometheus.Desc
+	sentDesc            *prometheus.Desc
+	receivedDesc        *prometheus.Desc
+	lostDesc            *prometheus.Desc
+	targetUpDesc        *prometheus.Desc
+	lastReplyDesc       *prometheus.Desc
+	consecutiveLostDesc *prometheus.Desc
+	timeoutsDesc        *prometheus.Desc
+	sendErrorsDesc      *prometheus.Desc
+	refusedDesc         *prometheus.Desc
+	icmpErrorsDesc      *prometheus.Desc
+	rFactorDesc         *prometheus.Desc
+	mosDesc             *prometheus.Desc
+	replyTTLDesc        *prometheus.Desc
+	hopCountDesc        *prometheus.Desc
+	neighborDesc        *prometheus.Desc
+	ifaceStateDesc      *prometheus.Desc
+	ifaceCodeDesc       *prometheus.Desc
+	duplicateDesc       *prometheus.Desc
+	lateDesc            *prometheus.Desc
+	reorderedDesc       *prometheus.Desc
+	lateDelayDesc       *prometheus.Desc
+	corruptedDesc       *prometheus.Desc
+	truncatedDesc       *prometheus.Desc
+	pathMTUDesc         *prometheus.Desc
+	pathMTUFailuresDesc *prometheus.Desc
+	blackHolesDesc      *prometheus.Desc
+	oneWayDelayDesc     *prometheus.Desc
+	clockOffsetDesc     *prometheus.Desc
+	burstsDesc          *prometheus.Desc
+	burstsLostDesc      *prometheus.Desc
+	burstsPartialDesc   *prometheus.Desc
+	hopRTTDesc          *prometheus.Desc
+	hopLossDesc         *prometheus.Desc
+	ageDesc             *prometheus.Desc
+	progDesc            *prometheus.Desc
 }
 
-func NewPingCollector(enableDeprecatedMetrics bool, unit rttUnit, monitor *mon.Monitor, cfg *config.Config) *pingCollector {
+func NewPingCollector(enableDeprecatedMetrics bool, unit rttUnit, monitors *monitorSet, cfg *config.Config) *pingCollector {
 	ret := &pingCollector{
-		monitor:                 monitor,
+		monitors:                monitors,
 		enableDeprecatedMetrics: enableDeprecatedMetrics,
 		rttUnit:                 unit,
 		cfg:                     cfg,
+		stats:                   newTargetStatsSet(),
 	}
 	ret.customLabels = newCustomLabelSet(cfg.Targets)
+	ret.indexTargetConfigs()
 	ret.createDesc()
+	monitors.AddObserver(ret)
 	return ret
 }
 
 func (p *pingCollector) UpdateConfig(cfg *config.Config) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
-	p.cfg.Targets = cfg.Targets
+	p.cfg = cfg
 	p.customLabels = newCustomLabelSet(cfg.Targets)
+	p.indexTargetConfigs()
 	p.createDesc()
 }
 
@@ -61,7 +106,46 @@ func (p *pingCollector) Describe(ch chan<- *prometheus.Desc) {
 	p.worstDesc.Describe(ch)
 	p.meanDesc.Describe(ch)
 	p.stddevDesc.Describe(ch)
+	p.jitterDesc.Describe(ch)
+	if len(p.cfg.Ping.Quantiles) > 0 {
+		p.quantileDesc.Describe(ch)
+	}
+	ch <- p.rttHistDesc
 	ch <- p.lossDesc
+	ch <- p.sentDesc
+	ch <- p.receivedDesc
+	ch <- p.lostDesc
+	ch <- p.targetUpDesc
+	ch <- p.lastReplyDesc
+	ch <- p.consecutiveLostDesc
+	ch <- p.timeoutsDesc
+	ch <- p.sendErrorsDesc
+	ch <- p.refusedDesc
+	ch <- p.icmpErrorsDesc
+	ch <- p.rFactorDesc
+	ch <- p.mosDesc
+	ch <- p.replyTTLDesc
+	ch <- p.hopCountDesc
+	ch <- p.neighborDesc
+	ch <- p.ifaceStateDesc
+	ch <- p.ifaceCodeDesc
+	ch <- p.duplicateDesc
+	ch <- p.lateDesc
+	ch <- p.reorderedDesc
+	ch <- p.lateDelayDesc
+	ch <- p.corruptedDesc
+	ch <- p.truncatedDesc
+	ch <- p.pathMTUDesc
+	ch <- p.pathMTUFailuresDesc
+	ch <- p.blackHolesDesc
+	ch <- p.oneWayDelayDesc
+	ch <- p.clockOffsetDesc
+	ch <- p.burstsDesc
+	ch <- p.burstsLostDesc
+	ch <- p.burstsPartialDesc
+	ch <- p.hopRTTDesc
+	ch <- p.hopLossDesc
+	ch <- p.ageDesc
 	ch <- p.progDesc
 }
 
@@ -69,22 +153,32 @@ func (p *pingCollector) Collect(ch chan<- prometheus.Metric) {
 	p.mutex.Lock()
 	defer p.mutex.Unlock()
 
-	if m := p.monitor.Export(); len(m) > 0 {
-		p.metrics = m
-	}
+	m := p.monitors.Export()
+	p.stats.retain(keysOf(m))
 
 	ch <- prometheus.MustNewConstMetric(p.progDesc, prometheus.GaugeValue, 1)
 
-	for target, metrics := range p.metrics {
-		l := strings.SplitN(target, " ", 3)
+	now := time.Now()
+	for target, metrics := range m {
+		l := labelsOfKey(target)
 
-		targetConfig := p.cfg.TargetConfigByAddr(l[0])
+		targetConfig := p.targetConfig(l)
 		l = append(l, p.customLabels.labelValues(targetConfig)...)
 
 		if metrics.PacketsSent == 0 {
 			continue
 		}
 
+		staleAfter := metrics.StaleAfter
+		if d := p.cfg.Metrics.StaleAfter.Duration(); d > 0 {
+			staleAfter = d
+		}
+		age := now.Sub(metrics.Updated)
+		if staleAfter > 0 && age > staleAfter {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(p.ageDesc, prometheus.GaugeValue, age.Seconds(), l...)
+
 		if metrics.PacketsSent > metrics.PacketsLost {
 			if p.enableDeprecatedMetrics {
 				p.rttDesc.Collect(ch, metrics.Best, append(l, "best")...)
@@ -97,26 +191,279 @@ func (p *pingCollector) Collect(ch chan<- prometheus.Metric) {
 			p.worstDesc.Collect(ch, metrics.Worst, l...)
 			p.meanDesc.Collect(ch, metrics.Mean, l...)
 			p.stddevDesc.Collect(ch, metrics.StdDev, l...)
+
+			if !math.IsNaN(float64(metrics.JitterRFC3550)) {
+				p.jitterDesc.Collect(ch, metrics.JitterRFC3550, append(l, "rfc3550")...)
+			}
+			if !math.IsNaN(float64(metrics.JitterConsecutive)) {
+				p.jitterDesc.Collect(ch, metrics.JitterConsecutive, append(l, "consecutive_diff")...)
+			}
+
+			for _, q := range p.cfg.Ping.Quantiles {
+				p.quantileDesc.Collect(ch, metrics.quantile(q), append(l, formatQuantile(q))...)
+			}
+		}
+
+		if st := p.stats.get(target); st != nil {
+			if st.rttHistogram != nil {
+				ch <- newLabeledHistogram(p.rttHistDesc, st.rttHistogram, l...)
+			}
+
+			ch <- prometheus.MustNewConstMetric(p.sentDesc, prometheus.CounterValue, float64(st.packetsSent), l...)
+			ch <- prometheus.MustNewConstMetric(p.receivedDesc, prometheus.CounterValue, float64(st.packetsReceived), l...)
+			ch <- prometheus.MustNewConstMetric(p.lostDesc, prometheus.CounterValue, float64(st.packetsLost()), l...)
+
+			ch <- prometheus.MustNewConstMetric(p.targetUpDesc, prometheus.GaugeValue, boolToFloat(st.up()), l...)
+			ch <- prometheus.MustNewConstMetric(p.consecutiveLostDesc, prometheus.GaugeValue, float64(st.consecutiveLost), l...)
+			ch <- prometheus.MustNewConstMetric(p.timeoutsDesc, prometheus.CounterValue, float64(st.timeouts), l...)
+			ch <- prometheus.MustNewConstMetric(p.sendErrorsDesc, prometheus.CounterValue, float64(st.sendErrors), l...)
+			ch <- prometheus.MustNewConstMetric(p.refusedDesc, prometheus.CounterValue, float64(st.connectionsRefused), l...)
+			for k, n := range st.icmpErrors {
+				ch <- prometheus.MustNewConstMetric(p.icmpErrorsDesc, prometheus.CounterValue, float64(n), append(l, k.typ, strconv.Itoa(k.code), k.reporter)...)
+			}
+			if !st.lastReply.IsZero() {
+				ch <- prometheus.MustNewConstMetric(p.lastReplyDesc, prometheus.GaugeValue, float64(st.lastReply.UnixNano())/1e9, l...)
+			}
+
+			if st.replyTTL > 0 {
+				ch <- prometheus.MustNewConstMetric(p.replyTTLDesc, prometheus.GaugeValue, float64(st.replyTTL), l...)
+				ch <- prometheus.MustNewConstMetric(p.hopCountDesc, prometheus.GaugeValue, float64(st.hopCount()), l...)
+			}
+			if st.mac != "" {
+				ch <- prometheus.MustNewConstMetric(p.neighborDesc, prometheus.GaugeValue, 1, append(l, st.mac)...)
+			}
+			if s := st.iface; s != nil {
+				li := append(l, targetConfig.ProbeInterface)
+				ch <- prometheus.MustNewConstMetric(p.ifaceCodeDesc, prometheus.GaugeValue, float64(s.code), li...)
+				if s.code == 0 {
+					ch <- prometheus.MustNewConstMetric(p.ifaceStateDesc, prometheus.GaugeValue, boolToFloat(s.active), append(li, "active")...)
+					ch <- prometheus.MustNewConstMetric(p.ifaceStateDesc, prometheus.GaugeValue, boolToFloat(s.ipv4), append(li, "ipv4")...)
+					ch <- prometheus.MustNewConstMetric(p.ifaceStateDesc, prometheus.GaugeValue, boolToFloat(s.ipv6), append(li, "ipv6")...)
+				}
+			}
+
+			ch <- prometheus.MustNewConstMetric(p.duplicateDesc, prometheus.CounterValue, float64(st.duplicateReplies), l...)
+			ch <- prometheus.MustNewConstMetric(p.lateDesc, prometheus.CounterValue, float64(st.lateReplies), l...)
+			ch <- prometheus.MustNewConstMetric(p.reorderedDesc, prometheus.CounterValue, float64(st.reorderedReplies), l...)
+			if st.lateDelay != nil {
+				ch <- newLabeledHistogram(p.lateDelayDesc, st.lateDelay, l...)
+			}
+			ch <- prometheus.MustNewConstMetric(p.corruptedDesc, prometheus.CounterValue, float64(st.corruptedReplies), l...)
+			ch <- prometheus.MustNewConstMetric(p.truncatedDesc, prometheus.CounterValue, float64(st.truncatedReplies), l...)
+
+			if targetConfig.PathMTU {
+				if st.pathMTU > 0 {
+					ch <- prometheus.MustNewConstMetric(p.pathMTUDesc, prometheus.GaugeValue, float64(st.pathMTU), l...)
+				}
+				ch <- prometheus.MustNewConstMetric(p.pathMTUFailuresDesc, prometheus.CounterValue, float64(st.pathMTUFailures), l...)
+				ch <- prometheus.MustNewConstMetric(p.blackHolesDesc, prometheus.CounterValue, float64(st.pathMTUBlackHoles), l...)
+			}
+
+			if d := st.oneWayDelays; d != nil {
+				ch <- prometheus.MustNewConstMetric(p.oneWayDelayDesc, prometheus.GaugeValue, d.forward.Seconds(), append(l, "forward")...)
+				ch <- prometheus.MustNewConstMetric(p.oneWayDelayDesc, prometheus.GaugeValue, d.back.Seconds(), append(l, "return")...)
+				ch <- prometheus.MustNewConstMetric(p.clockOffsetDesc, prometheus.GaugeValue, d.offset.Seconds(), l...)
+			}
+
+			if st.bursts > 0 {
+				ch <- prometheus.MustNewConstMetric(p.burstsDesc, prometheus.CounterValue, float64(st.bursts), l...)
+				ch <- prometheus.MustNewConstMetric(p.burstsLostDesc, prometheus.CounterValue, float64(st.burstsLost), l...)
+				ch <- prometheus.MustNewConstMetric(p.burstsPartialDesc, prometheus.CounterValue, float64(st.burstsPartiallyLost), l...)
+			}
+		}
+
+		for _, h := range metrics.Hops {
+			hop := strconv.Itoa(h.Hop)
+			ch <- prometheus.MustNewConstMetric(p.hopLossDesc, prometheus.GaugeValue, h.LossRatio, append(l, hop, h.Address)...)
+			for _, rtt := range h.RTTs {
+				ch <- prometheus.MustNewConstMetric(p.hopRTTDesc, prometheus.GaugeValue, rtt.Mean, append(l, hop, rtt.Address)...)
+			}
 		}
 
 		loss := float64(metrics.PacketsLost) / float64(metrics.PacketsSent)
 		ch <- prometheus.MustNewConstMetric(p.lossDesc, prometheus.GaugeValue, loss, l...)
+
+		r := rFactor(targetConfig.EModel, eModelInputFromMetrics(metrics))
+		ch <- prometheus.MustNewConstMetric(p.rFactorDesc, prometheus.GaugeValue, r, l...)
+		ch <- prometheus.MustNewConstMetric(p.mosDesc, prometheus.GaugeValue, mos(r), l...)
 	}
 }
 
 func (p *pingCollector) createDesc() {
-	labelNames := []string{"target", "ip", "ip_version"}
-	labelNames = append(labelNames, p.customLabels.labelNames()...)
+	labelNames := slices.Concat(targetLabelNames, p.customLabels.labelNames())
 
-	p.rttDesc = newScaledDesc("rtt", "Round trip time", p.rttUnit, append(labelNames, "type"))
+	// ping_rtt_seconds is the histogram, the deprecated gauge is only exported in millis
+	p.rttDesc = newScaledDesc("rtt", "Round trip time", p.rttUnit.millisOnly(), append(labelNames, "type"))
 	p.bestDesc = newScaledDesc("rtt_best", "Best round trip time", p.rttUnit, labelNames)
 	p.worstDesc = newScaledDesc("rtt_worst", "Worst round trip time", p.rttUnit, labelNames)
 	p.meanDesc = newScaledDesc("rtt_mean", "Mean round trip time", p.rttUnit, labelNames)
 	p.stddevDesc = newScaledDesc("rtt_std_deviation", "Standard deviation", p.rttUnit, labelNames)
+	p.quantileDesc = newScaledDesc("rtt_quantile", "Quantile of the round trip times", p.rttUnit, append(labelNames, "quantile"))
+	p.jitterDesc = newScaledDesc("jitter", "Jitter of the round trip times", p.rttUnit, append(labelNames, "method"))
+	p.rttHistDesc = newDesc("rtt_seconds", "Round trip time of the echo replies in seconds", labelNames, nil)
 	p.lossDesc = newDesc("loss_ratio", "Packet loss from 0.0 to 1.0", labelNames, nil)
+	p.sentDesc = newDesc("packets_sent_total", "Number of echo requests sent", labelNames, nil)
+	p.receivedDesc = newDesc("packets_received_total", "Number of echo replies received", labelNames, nil)
+	p.lostDesc = newDesc("packets_lost_total", "Number of echo requests without reply", labelNames, nil)
+	p.targetUpDesc = newDesc("target_up", "Whether the latest echo request has been answered (1) or not (0)", labelNames, nil)
+	p.lastReplyDesc = newDesc("last_reply_timestamp_seconds", "Unix timestamp of the latest echo reply", labelNames, nil)
+	p.consecutiveLostDesc = newDesc("consecutive_lost_packets", "Number of echo requests without reply since the latest reply", labelNames, nil)
+	p.timeoutsDesc = newDesc("timeouts_total", "Number of echo requests neither answered nor failed until the timeout", labelNames, nil)
+	p.sendErrorsDesc = newDesc("send_errors_total", "Number of echo requests which could not be sent, e.g. without route to the target", labelNames, nil)
+	p.refusedDesc = newDesc("connections_refused_total", "Number of TCP probes refused by the target", labelNames, nil)
+	p.icmpErrorsDesc = newDesc("icmp_errors_total", "Number of echo requests answered by an ICMP error message, by type, code and sender of the message", append(labelNames, "type", "code", "reporter"), nil)
+	p.rFactorDesc = newDesc("r_factor", "Transmission rating factor R according to the simplified E-model (ITU-T G.107)", labelNames, nil)
+	p.mosDesc = newDesc("mos", "Estimated mean opinion score from 1 to 4.5 derived from the R-factor", labelNames, nil)
+	p.replyTTLDesc = newDesc("reply_ttl", "TTL (IPv4) or hop limit (IPv6) of the latest echo reply", labelNames, nil)
+	p.hopCountDesc = newDesc("hop_count", "Estimated number of hops to the target, based on the nearest common initial TTL (64, 128 or 255)", labelNames, nil)
+	p.neighborDesc = newDesc("neighbor_info", "Link layer address of the latest ARP or NDP reply of the target", append(labelNames, "mac"), nil)
+	p.ifaceStateDesc = newDesc("interface_state", "State flags (active, ipv4, ipv6) of the queried interface reported by the latest extended echo reply", append(labelNames, "interface", "flag"), nil)
+	p.ifaceCodeDesc = newDesc("interface_reply_code", "Code of the latest extended echo reply (0 no error, 1 malformed query, 2 no such interface, 3 no such table entry, 4 multiple interfaces)", append(labelNames, "interface"), nil)
+	p.duplicateDesc = newDesc("duplicate_replies_total", "Number of duplicate echo replies", labelNames, nil)
+	p.lateDesc = newDesc("late_replies_total", "Number of echo replies received after the timeout", labelNames, nil)
+	p.reorderedDesc = newDesc("reordered_replies_total", "Number of echo replies received after the reply to a newer request", labelNames, nil)
+	p.lateDelayDesc = newDesc("late_reply_delay_seconds", "Time elapsed between timeout and arrival of late echo replies in seconds", labelNames, nil)
+	p.corruptedDesc = newDesc("corrupted_replies_total", "Number of echo replies with a payload differing from the request", labelNames, nil)
+	p.truncatedDesc = newDesc("truncated_replies_total", "Number of echo replies with a payload shorter than the request", labelNames, nil)
+	p.pathMTUDesc = newDesc("path_mtu_bytes", "Size of the largest IP packet with DF flag answered, according to the latest path MTU discovery", labelNames, nil)
+	p.pathMTUFailuresDesc = newDesc("path_mtu_discovery_failures_total", "Number of path MTU discoveries without any echo reply", labelNames, nil)
+	p.blackHolesDesc = newDesc("path_mtu_black_holes_total", "Number of path MTU discoveries with larger packets dropped without ICMP error (MTU black hole)", labelNames, nil)
+	p.oneWayDelayDesc = newDesc("one_way_delay_seconds", "One way delay of the latest ICMP timestamp request (forward) and reply (return) in seconds, including the clock offset of the target", append(labelNames, "direction"), nil)
+	p.clockOffsetDesc = newDesc("clock_offset_seconds", "Offset of the clock of the target estimated from the latest ICMP timestamp reply in seconds, assuming a symmetric path", labelNames, nil)
+	p.burstsDesc = newDesc("bursts_total", "Number of bursts of echo requests sent", labelNames, nil)
+	p.burstsLostDesc = newDesc("bursts_lost_total", "Number of bursts without any echo reply", labelNames, nil)
+	p.burstsPartialDesc = newDesc("bursts_partially_lost_total", "Number of bursts with some but not all echo requests unanswered", labelNames, nil)
+	p.hopRTTDesc = newDesc("hop_rtt_seconds", "Mean round trip time of the probes answered by a hop of the trace in seconds", append(labelNames, "hop", "hop_address"), nil)
+	p.hopLossDesc = newDesc("hop_loss_ratio", "Packet loss of a hop of the trace from 0.0 to 1.0, labeled with the latest responder", append(labelNames, "hop", "hop_address"), nil)
+	p.ageDesc = newDesc("result_age_seconds", "Time since the latest result of the target in seconds", labelNames, nil)
 	p.progDesc = newDesc("up", "ping_exporter version", nil, prometheus.Labels{"version": version})
 }
 
+// labelsOfKey returns target, ip, ip_version, probe, source, dscp, flow and port of a monitor key
+func labelsOfKey(key string) []string {
+	l := strings.SplitN(key, " ", 7)
+	if len(l) == 6 {
+		// no flows, the label is empty
+		l = append(l, "")
+	}
+
+	// the port is part of the probe in the key, empty if not configured
+	var port string
+	l[3], port, _ = strings.Cut(l[3], ":")
+	l = append(l, port)
+
+	// the key contains the whole traffic class including the ECN bits
+	tos, _ := strconv.Atoi(l[5])
+	l[5] = socketOptions{tos: tos}.dscpLabel()
+
+	return l
+}
+
+// indexTargetConfigs maps the labels identifying a target to its config, so
+// results can be related to their target without scanning the config. Needs
+// to be called with the mutex held.
+func (p *pingCollector) indexTargetConfigs() {
+	p.targetConfigs = make(map[string]config.TargetConfig, len(p.cfg.Targets))
+	for _, t := range p.cfg.Targets {
+		o := socketOptionsOf(t, p.cfg)
+		p.targetConfigs[targetConfigKey(t.Addr, t.ProbeType(), portLabel(t.Port), o.sourceLabel(), o.dscpLabel())] = t
+	}
+}
+
+// targetConfigKey returns the key of a target in the index of target configs,
+// the series of a target are identified by these labels
+func targetConfigKey(addr, probe, port, source, dscp string) string {
+	return addr + " " + probe + " " + port + " " + source + " " + dscp
+}
+
+// portLabel returns the value of the port label, empty if no port is configured
+func portLabel(port uint16) string {
+	if port == 0 {
+		return ""
+	}
+
+	return strconv.Itoa(int(port))
+}
+
+// targetConfig returns the config of the target the labels of a monitor key
+// belong to, needs to be called with the mutex held
+func (p *pingCollector) targetConfig(l []string) config.TargetConfig {
+	if t, found := p.targetConfigs[targetConfigKey(l[0], l[3], l[7], l[4], l[5])]; found {
+		return t
+	}
+
+	return config.TargetConfig{Addr: l[0]}
+}
+
+// observe implements the resultObserver interface
+func (p *pingCollector) observe(key string, reply echoReply, err error) {
+	l := labelsOfKey(key)
+
+	p.mutex.RLock()
+	layout := p.histogramLayout(p.targetConfig(l))
+	p.mutex.RUnlock()
+
+	p.stats.observe(key, layout, reply, err)
+}
+
+// observeReplyEvent implements the resultObserver interface
+func (p *pingCollector) observeReplyEvent(key string, ev replyEvent, delay time.Duration) {
+	p.stats.observeReplyEvent(key, ev, delay)
+}
+
+// observePathMTU implements the resultObserver interface
+func (p *pingCollector) observePathMTU(key string, r pathMTUResult) {
+	p.stats.observePathMTU(key, r)
+}
+
+// observeBurst implements the resultObserver interface
+func (p *pingCollector) observeBurst(key string, sent, lost int) {
+	p.stats.observeBurst(key, sent, lost)
+}
+
+// observeOneWayDelays implements the resultObserver interface
+func (p *pingCollector) observeOneWayDelays(key string, d oneWayDelays) {
+	p.stats.observeOneWayDelays(key, d)
+}
+
+// histogramLayout returns the RTT histogram layout of a target, falling back to the global one
+func (p *pingCollector) histogramLayout(t config.TargetConfig) histogramLayout {
+	l := histogramLayout{
+		buckets:            p.cfg.Metrics.RTTBuckets,
+		nativeBucketFactor: p.cfg.Metrics.RTTNativeBucketFactor,
+	}
+
+	if len(t.RTTBuckets) > 0 {
+		l.buckets = t.RTTBuckets
+	}
+	if t.RTTNativeBucketFactor > 0 {
+		l.nativeBucketFactor = t.RTTNativeBucketFactor
+	}
+
+	return l
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+func formatQuantile(q float64) string {
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+func keysOf[V any](m map[string]V) map[string]bool {
+	ret := make(map[string]bool, len(m))
+	for k := range m {
+		ret[k] = true
+	}
+
+	return ret
+}
+
 func newDesc(name, help string, variableLabels []string, constLabels prometheus.Labels) *prometheus.Desc {
 	return prometheus.NewDesc("ping_"+name, help, variableLabels, constLabels)
 }
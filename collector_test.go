@@ -0,0 +1,147 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestPingCollector_Collect_staleAfter(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ping.Interval.Set(5 * time.Second)
+	cfg.Ping.Timeout.Set(time.Second)
+
+	monitors := newMonitorSet()
+	c := NewPingCollector(false, rttInSeconds, monitors, cfg)
+
+	// the latest result of the slow target is older than three times the
+	// global interval, but not than three times its own
+	addTargetWithResult(monitors.get(monitorSchedule{interval: 5 * time.Minute, timeout: time.Second, historySize: 10}),
+		"slow 192.0.2.1 4 icmp  0", time.Minute)
+	addTargetWithResult(monitors.get(monitorSchedule{interval: 5 * time.Second, timeout: time.Second, historySize: 10}),
+		"fast 192.0.2.2 4 icmp  0", time.Minute)
+
+	got := collectResultAges(t, c)
+	if _, found := got["slow"]; !found {
+		t.Error("expected series of slow target to be exported")
+	}
+	if _, found := got["fast"]; found {
+		t.Error("expected series of stale fast target to be dropped")
+	}
+}
+
+// addTargetWithResult adds a target with a result of the given age to m
+func addTargetWithResult(m *monitor, key string, age time.Duration) {
+	h := newHistory(m.HistorySize)
+	h.add(time.Millisecond, nil)
+	h.updated = time.Now().Add(-age)
+
+	m.targets[key] = &monitorTarget{key: key, monitor: m, history: h}
+}
+
+// collectResultAges returns the result ages collected by target label
+func collectResultAges(t *testing.T, c *pingCollector) map[string]float64 {
+	ch := make(chan prometheus.Metric, 1000)
+	c.Collect(ch)
+	close(ch)
+
+	ret := make(map[string]float64)
+	for metric := range ch {
+		if metric.Desc() != c.ageDesc {
+			continue
+		}
+
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range m.GetLabel() {
+			if l.GetName() == "target" {
+				ret[l.GetValue()] = m.GetGauge().GetValue()
+			}
+		}
+	}
+
+	return ret
+}
+
+func TestPingCollector_deprecatedMetrics(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ping.Interval.Set(5 * time.Second)
+	cfg.Ping.Timeout.Set(time.Second)
+	cfg.Metrics.RTTBuckets = []float64{0.001, 0.01, 0.1}
+	cfg.Metrics.RTTNativeBucketFactor = 1
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com"}}
+
+	monitors := newMonitorSet()
+	c := NewPingCollector(true, rttBoth, monitors, cfg)
+
+	key := "example.com 192.0.2.1 4 icmp  0"
+	addTargetWithResult(monitors.get(monitorSchedule{interval: 5 * time.Second, timeout: time.Second, historySize: 10}), key, 0)
+	c.observe(key, echoReply{rtt: 5 * time.Millisecond}, nil)
+
+	reg := prometheus.NewPedanticRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatal(err)
+	}
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types := make(map[string]dto.MetricType)
+	for _, f := range families {
+		types[f.GetName()] = f.GetType()
+	}
+	if typ, found := types["ping_rtt_seconds"]; !found || typ != dto.MetricType_HISTOGRAM {
+		t.Errorf("expected ping_rtt_seconds histogram, got %v", types)
+	}
+	if _, found := types["ping_rtt_ms"]; !found {
+		t.Error("expected deprecated ping_rtt_ms gauge")
+	}
+}
+
+func TestPingCollector_Collect_staleAndRemoved(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ping.Interval.Set(5 * time.Second)
+	cfg.Ping.Timeout.Set(time.Second)
+	cfg.Metrics.RTTBuckets = []float64{0.001, 0.01, 0.1}
+	cfg.Metrics.RTTNativeBucketFactor = 1
+
+	monitors := newMonitorSet()
+	c := NewPingCollector(false, rttInSeconds, monitors, cfg)
+	m := monitors.get(monitorSchedule{interval: 5 * time.Second, timeout: time.Second, historySize: 10})
+
+	key := "removed 192.0.2.1 4 icmp  0"
+	addTargetWithResult(m, key, 0)
+	addTargetWithResult(m, "kept 192.0.2.2 4 icmp  0", 0)
+	c.observe(key, echoReply{rtt: time.Millisecond}, nil)
+
+	if got := collectResultAges(t, c); len(got) != 2 {
+		t.Fatalf("expected 2 targets, got %v", got)
+	}
+
+	m.mutex.Lock()
+	delete(m.targets, key)
+	m.mutex.Unlock()
+
+	got := collectResultAges(t, c)
+	if _, found := got["removed"]; found || len(got) != 1 {
+		t.Errorf("expected removed target to disappear with the next scrape, got %v", got)
+	}
+	if c.stats.get(key) != nil {
+		t.Error("expected stats of removed target to be dropped")
+	}
+
+	cfg.Metrics.StaleAfter.Set(30 * time.Second)
+	m.targets["kept 192.0.2.2 4 icmp  0"].history.updated = time.Now().Add(-time.Minute)
+	if got := collectResultAges(t, c); len(got) != 0 {
+		t.Errorf("expected stale target to be dropped, got %v", got)
+	}
+}
@@ -14,19 +14,38 @@ type Config struct {
 	Targets []TargetConfig `yaml:"targets"`
 
 	Ping struct {
-		Interval     duration `yaml:"interval"`
-		Timeout      duration `yaml:"timeout"`
-		History      int      `yaml:"history-size"`
-		Size         uint16   `yaml:"payload-size"`
-		FirewallMark uint     `yaml:"fw-mark"`
+		Interval     duration  `yaml:"interval"`
+		Timeout      duration  `yaml:"timeout"`
+		History      int       `yaml:"history-size"`
+		Size         uint16    `yaml:"payload-size"`
+		Pattern      string    `yaml:"payload-pattern"`
+		FirewallMark uint      `yaml:"fw-mark"`
+		BurstCount   int       `yaml:"burst-count"`   // requests per interval
+		BurstSpacing duration  `yaml:"burst-spacing"` // time between the requests of a burst
+		Quantiles    []float64 `yaml:"quantiles,omitempty,flow"`
+		Unprivileged bool      `yaml:"unprivileged,omitempty"`
+
+		BindingConfig      `yaml:",inline"`
+		TrafficClassConfig `yaml:",inline"`
 	} `yaml:"ping"`
 
+	Trace struct {
+		MaxHops         int `yaml:"max-hops"`          // highest TTL probed
+		MaxHopAddresses int `yaml:"max-hop-addresses"` // number of responders per hop exported
+	} `yaml:"trace"`
+
 	DNS struct {
 		Refresh    duration `yaml:"refresh"`
 		Nameserver string   `yaml:"nameserver"`
 		Timeout    duration `yaml:"timeout"`
 	} `yaml:"dns"`
 
+	Metrics struct {
+		RTTBuckets            []float64 `yaml:"rtt-buckets,omitempty,flow"`
+		RTTNativeBucketFactor float64   `yaml:"rtt-native-bucket-factor"`
+		StaleAfter            duration  `yaml:"stale-after"`
+	} `yaml:"metrics"`
+
 	Options struct {
 		DisableIPv6 bool `yaml:"disableIPv6"` // prohibits DNS resolved IPv6 addresses
 		DisableIPv4 bool `yaml:"disableIPv4"` // prohibits DNS resolved IPv4 addresses
@@ -53,13 +72,3 @@ func ToYAML(w io.Writer, cfg *Config) error {
 
 	return nil
 }
-
-func (cfg *Config) TargetConfigByAddr(addr string) TargetConfig {
-	for _, t := range cfg.Targets {
-		if t.Addr == addr {
-			return t
-		}
-	}
-
-	return TargetConfig{Addr: addr}
-}
@@ -27,6 +27,7 @@ func TestParseConfig(t *testing.T) {
 		t.FailNow()
 	}
 
+	dscp := uint8(46)
 	targets := []TargetConfig{
 		{Addr: "8.8.8.8"},
 		{Addr: "8.8.4.4"},
@@ -37,10 +38,54 @@ func TestParseConfig(t *testing.T) {
 				"foo": "bar",
 			},
 		},
+		{
+			Addr:                  "1.1.1.1",
+			RTTBuckets:            []float64{0.01, 0.02, 0.05},
+			RTTNativeBucketFactor: 1.2,
+			Flows:                 8,
+		},
+		{
+			Addr: "1.0.0.1",
+			EModel: EModelConfig{
+				Ie:         11,
+				Bpl:        19,
+				CodecDelay: duration(25 * time.Millisecond),
+			},
+		},
+		{
+			Addr:  "8.8.8.8",
+			Probe: ProbeTCP,
+			Port:  53,
+			BindingConfig: BindingConfig{
+				SourceAddress: "192.0.2.1",
+				Interface:     "eth1",
+			},
+			TrafficClassConfig: TrafficClassConfig{
+				DSCP: &dscp,
+			},
+		},
+		{
+			Addr:         "192.0.2.10",
+			Interval:     duration(time.Second),
+			Timeout:      duration(500 * time.Millisecond),
+			History:      60,
+			Size:         1400,
+			Pattern:      PayloadDSL,
+			FirewallMark: 7,
+			BurstCount:   5,
+			BurstSpacing: duration(20 * time.Millisecond),
+			Trace:        true,
+			Timestamp:    true,
+		},
+		{
+			Addr:           "192.0.2.11",
+			Probe:          ProbeExtendedEcho,
+			ProbeInterface: "ge-0/0/1",
+		},
 	}
 
 	if !reflect.DeepEqual(targets, c.Targets) {
-		t.Errorf("expected 4 targets (%v) but got %d (%v)", targets, len(c.Targets), c.Targets)
+		t.Errorf("expected 9 targets (%v) but got %d (%v)", targets, len(c.Targets), c.Targets)
 		t.FailNow()
 	}
 
@@ -54,6 +99,13 @@ func TestParseConfig(t *testing.T) {
 		t.Errorf("expected dns.nameserver to be %q, got %q", expected, c.DNS.Nameserver)
 	}
 
+	if expected := 16; c.Trace.MaxHops != expected {
+		t.Errorf("expected trace.max-hops to be %d, got %d", expected, c.Trace.MaxHops)
+	}
+	if expected := 2; c.Trace.MaxHopAddresses != expected {
+		t.Errorf("expected trace.max-hop-addresses to be %d, got %d", expected, c.Trace.MaxHopAddresses)
+	}
+
 	if expected := 2 * time.Second; time.Duration(c.Ping.Interval) != expected {
 		t.Errorf("expected ping.interval to be %v, got %v", expected, c.Ping.Interval)
 	}
@@ -66,6 +118,12 @@ func TestParseConfig(t *testing.T) {
 	if expected := 120; c.Ping.Size != uint16(expected) {
 		t.Errorf("expected ping.payload-size to be %d, got %d", expected, c.Ping.Size)
 	}
+	if expected := []float64{0.5, 0.99}; !reflect.DeepEqual(c.Ping.Quantiles, expected) {
+		t.Errorf("expected ping.quantiles to be %v, got %v", expected, c.Ping.Quantiles)
+	}
+	if expected := []float64{0.001, 0.01, 0.1}; !reflect.DeepEqual(c.Metrics.RTTBuckets, expected) {
+		t.Errorf("expected metrics.rtt-buckets to be %v, got %v", expected, c.Metrics.RTTBuckets)
+	}
 	if expected := true; c.Options.DisableIPv6 != expected {
 		t.Errorf("expected options.disable-ipv6 to be %v, got %v", expected, c.Options.DisableIPv6)
 	}
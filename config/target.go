@@ -1,10 +1,112 @@
 package config
 
-import "maps"
+import "reflect"
 
+// probe types of a target
+const (
+	ProbeICMP         = "icmp"          // ICMP echo request
+	ProbeTCP          = "tcp"           // TCP handshake
+	ProbeUDPEcho      = "udp-echo"      // UDP echo (RFC 862)
+	ProbeTWAMPLight   = "twamp-light"   // TWAMP-Light test packets (RFC 5357)
+	ProbeARP          = "arp"           // ARP request (IPv4) or neighbor solicitation (IPv6)
+	ProbeExtendedEcho = "extended-echo" // ICMP extended echo request (RFC 8335)
+)
+
+// payload patterns of ICMP and UDP probes
+const (
+	PayloadRandom = "random" // random bytes, the same for all probes of a socket
+	PayloadZeros  = "zeros"  // 0x00
+	PayloadOnes   = "ones"   // 0xFF
+	PayloadDSL    = "dsl"    // alternating HDLC flag and escape bytes (0x7E 0x7D)
+)
+
+// TargetConfig represents a single target in the config file. Keys not
+// known as a setting are exported as custom labels.
 type TargetConfig struct {
-	Addr   string
-	Labels map[string]string
+	Addr   string            `yaml:"host"`
+	Labels map[string]string `yaml:",inline"`
+
+	Probe string `yaml:"probe,omitempty"` // icmp (default), tcp, udp-echo, twamp-light, arp or extended-echo
+	Port  uint16 `yaml:"port,omitempty"`  // destination port of tcp and udp probes
+
+	// interface of the target queried by extended-echo probes, by name, index or address
+	ProbeInterface string `yaml:"probe-interface,omitempty"`
+
+	// overrides of the ping settings, 0 for the global setting
+	Interval     duration `yaml:"interval,omitempty"`
+	Timeout      duration `yaml:"timeout,omitempty"`
+	History      int      `yaml:"history-size,omitempty"`
+	Size         uint16   `yaml:"payload-size,omitempty"`
+	Pattern      string   `yaml:"payload-pattern,omitempty"` // see PayloadRandom
+	FirewallMark uint     `yaml:"fw-mark,omitempty"`
+	BurstCount   int      `yaml:"burst-count,omitempty"`
+	BurstSpacing duration `yaml:"burst-spacing,omitempty"`
+
+	// discover the path MTU by echo requests with DF flag up to the payload size
+	PathMTU bool `yaml:"path-mtu,omitempty"`
+
+	// estimate the one way delays by ICMP timestamp requests (IPv4 only)
+	Timestamp bool `yaml:"timestamp,omitempty"`
+
+	// trace the hops to the target with TTL limited probes, see Config.Trace
+	Trace bool `yaml:"trace,omitempty"`
+
+	// probe the target by several flows (ICMP checksum, UDP source port) to
+	// cover the paths of ECMP, 0 to disable
+	Flows int `yaml:"flows,omitempty"`
+
+	BindingConfig      `yaml:",inline"`
+	TrafficClassConfig `yaml:",inline"`
+
+	RTTBuckets            []float64 `yaml:"rtt-buckets,omitempty,flow"`
+	RTTNativeBucketFactor float64   `yaml:"rtt-native-bucket-factor,omitempty"`
+
+	EModel EModelConfig `yaml:"e-model,omitempty"`
+}
+
+// BindingConfig selects the egress path of the probes, set per target it
+// overrides the global settings
+type BindingConfig struct {
+	SourceAddress string `yaml:"source-address,omitempty"`
+	Interface     string `yaml:"interface,omitempty"` // bind to the device (SO_BINDTODEVICE)
+	VRF           string `yaml:"vrf,omitempty"`       // bind to the device of the VRF
+}
+
+// TrafficClassConfig sets the DSCP or the raw TOS (IPv4) / traffic class
+// (IPv6) of the probes, set per target it overrides the global settings
+type TrafficClassConfig struct {
+	DSCP *uint8 `yaml:"dscp,omitempty"`
+	TOS  *uint8 `yaml:"tos,omitempty"`
+}
+
+// TrafficClass returns the TOS / traffic class and whether it is set
+func (c TrafficClassConfig) TrafficClass() (int, bool) {
+	switch {
+	case c.DSCP != nil:
+		return int(*c.DSCP) << 2, true
+	case c.TOS != nil:
+		return int(*c.TOS), true
+	default:
+		return 0, false
+	}
+}
+
+// EModelConfig holds the codec parameters used to calculate the R-factor
+// and MOS of a target (ITU-T G.107)
+type EModelConfig struct {
+	Ie         float64  `yaml:"ie,omitempty"`          // equipment impairment factor, 0 for G.711
+	Bpl        float64  `yaml:"bpl,omitempty"`         // packet-loss robustness factor, defaults to 25.1 (G.711 with PLC)
+	Advantage  float64  `yaml:"advantage,omitempty"`   // advantage factor A
+	CodecDelay duration `yaml:"codec-delay,omitempty"` // packetization and codec delay added to the one way delay
+}
+
+// ProbeType returns the probe type of the target, ICMP if not set
+func (t TargetConfig) ProbeType() string {
+	if t.Probe == "" {
+		return ProbeICMP
+	}
+
+	return t.Probe
 }
 
 // UnmarshalYAML implements yaml.Unmarshaler interface.
@@ -12,38 +114,29 @@ func (t *TargetConfig) UnmarshalYAML(unmarshal func(any) error) error {
 	// If the input is a string, treat it as the Addr
 	var s string
 	if err := unmarshal(&s); err == nil {
-		t.Addr = s
-		t.Labels = nil
+		*t = TargetConfig{Addr: s}
 		return nil
 	}
 
-	// Temporary map to capture raw data
-	raw := make(map[string]string)
-	if err := unmarshal(&raw); err != nil {
-		return err
-	}
-
-	// Extract "host" key into Addr
-	if addr, ok := raw["host"]; ok {
-		t.Addr = addr
-		delete(raw, "host") // Remove from labels
-	}
-
-	// Store remaining keys as labels
-	t.Labels = raw
-	return nil
+	type plain TargetConfig
+	return unmarshal((*plain)(t))
 }
 
 func (t TargetConfig) MarshalYAML() (any, error) {
-	// If there are no labels, just return the address as a string
-	if len(t.Labels) == 0 {
+	// If there are neither labels nor settings, just return the address as a string
+	if t.isAddrOnly() {
 		return t.Addr, nil
 	}
 
-	// Otherwise, construct a map with "host" as Addr and other labels
-	m := make(map[string]string)
-	m["host"] = t.Addr
-	maps.Copy(m, t.Labels)
+	type plain TargetConfig
+	return plain(t), nil
+}
+
+func (t TargetConfig) isAddrOnly() bool {
+	if len(t.Labels) > 0 {
+		return false
+	}
 
-	return m, nil
+	t.Labels = nil
+	return reflect.DeepEqual(t, TargetConfig{Addr: t.Addr})
 }
@@ -1,6 +1,22 @@
 package main
 
-import "github.com/czerwonk/ping_exporter/config"
+import (
+	"slices"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// targetLabelNames are the labels identifying the series of a target
+var targetLabelNames = []string{"target", "ip", "ip_version", "probe", "source", "dscp", "flow", "port"}
+
+// metricLabelNames are the additional labels of single metrics
+var metricLabelNames = []string{"type", "quantile", "method", "code", "reporter", "mac", "interface", "flag", "direction", "hop", "hop_address"}
+
+// isReservedLabel returns whether the exporter sets the label name itself,
+// custom labels of the same name would collide with it
+func isReservedLabel(name string) bool {
+	return slices.Contains(targetLabelNames, name) || slices.Contains(metricLabelNames, name)
+}
 
 type customLabelSet struct {
 	names   []string
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"math"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+const (
+	// default packet-loss robustness factor (G.711 with packet loss concealment, ITU-T G.113)
+	defaultBpl = 25.1
+
+	// basic signal-to-noise ratio minus the simultaneous impairment factor with default values
+	eModelR0 = 93.2
+)
+
+// eModelInput are the network measures the E-model is calculated from
+type eModelInput struct {
+	meanRTT     float64 // in millis
+	jitter      float64 // in millis
+	lossPercent float64
+	replies     bool // at least one reply received, otherwise meanRTT and jitter are unknown
+}
+
+func eModelInputFromMetrics(m *resultMetrics) eModelInput {
+	in := eModelInput{
+		lossPercent: 100 * float64(m.PacketsLost) / float64(m.PacketsSent),
+		replies:     m.PacketsSent > m.PacketsLost,
+	}
+	if !in.replies {
+		return in
+	}
+
+	in.meanRTT = float64(m.Mean)
+	if !math.IsNaN(float64(m.JitterRFC3550)) {
+		in.jitter = float64(m.JitterRFC3550)
+	}
+
+	return in
+}
+
+// rFactor calculates the transmission rating factor according to the
+// simplified E-model of ITU-T G.107
+func rFactor(cfg config.EModelConfig, in eModelInput) float64 {
+	bpl := cfg.Bpl
+	if bpl <= 0 {
+		bpl = defaultBpl
+	}
+
+	// effective packet-loss equipment impairment factor (random loss)
+	ieEff := cfg.Ie + (95-cfg.Ie)*in.lossPercent/(in.lossPercent+bpl)
+
+	// delay impairment factor based on the one way delay including the jitter buffer
+	var id float64
+	if in.replies {
+		ta := in.meanRTT/2 + 2*in.jitter + float64(durationToMillis(cfg.CodecDelay.Duration()))
+		id = 0.024 * ta
+		if ta > 177.3 {
+			id += 0.11 * (ta - 177.3)
+		}
+	}
+
+	return eModelR0 - id - ieEff + cfg.Advantage
+}
+
+// mos converts a R-factor into the estimated mean opinion score (ITU-T G.107 Annex B)
+func mos(r float64) float64 {
+	switch {
+	case r <= 0:
+		return 1
+	case r >= 100:
+		return 4.5
+	default:
+		return 1 + 0.035*r + r*(r-60)*(100-r)*7e-6
+	}
+}
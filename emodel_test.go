@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestRFactor(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  config.EModelConfig
+		in   eModelInput
+		r    float64
+		mos  float64
+	}{
+		{
+			name: "no loss, low latency",
+			in:   eModelInput{meanRTT: 20, jitter: 2, replies: true},
+			r:    92.864,
+			mos:  4.40,
+		},
+		{
+			name: "high latency",
+			in:   eModelInput{meanRTT: 400, replies: true},
+			r:    93.2 - 0.024*200 - 0.11*(200-177.3),
+			mos:  4.23,
+		},
+		{
+			name: "loss with custom codec",
+			cfg:  config.EModelConfig{Ie: 11, Bpl: 19},
+			in:   eModelInput{meanRTT: 20, lossPercent: 5, replies: true},
+			r:    93.2 - 0.24 - 11 - 84*5.0/24,
+			mos:  3.33,
+		},
+		{
+			name: "everything lost",
+			in:   eModelInput{lossPercent: 100},
+			r:    93.2 - 95*100/125.1,
+			mos:  1.18,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := rFactor(test.cfg, test.in)
+			if math.Abs(r-test.r) > 0.001 {
+				t.Errorf("expected R-factor %.3f, got %.3f", test.r, r)
+			}
+			if m := mos(r); math.Abs(m-test.mos) > 0.01 {
+				t.Errorf("expected MOS %.2f, got %.2f", test.mos, m)
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/net/icmp"
+)
+
+// interface identification object of an ICMP extended echo request (RFC 8335, section 2.1)
+const (
+	classInterfaceIdent    = 3
+	typeInterfaceByName    = 1
+	typeInterfaceByIndex   = 2
+	typeInterfaceByAddress = 3
+
+	afiIPv4 = 1 // address family numbers (IANA)
+	afiIPv6 = 2
+)
+
+// interfaceState is the state of the queried interface reported by an ICMP
+// extended echo reply. The flags are only valid if code is 0.
+type interfaceState struct {
+	code   int // 0 no error, 1 malformed query, 2 no such interface, 3 no such table entry, 4 multiple interfaces
+	active bool
+	ipv4   bool // IPv4 is running on the interface
+	ipv6   bool // IPv6 is running on the interface
+}
+
+// extendedEchoProber queries the state of an interface of the target by ICMP
+// extended echo requests
+type extendedEchoProber struct {
+	pinger *sharedPinger
+	query  *icmp.InterfaceIdent
+}
+
+// newExtendedEchoProber returns a prober querying the interface identified by
+// name, index or address
+func newExtendedEchoProber(iface string, b socketOptions) (*extendedEchoProber, error) {
+	p, err := icmpPingers.acquire(b)
+	if err != nil {
+		return nil, err
+	}
+
+	return &extendedEchoProber{pinger: p, query: parseInterfaceQuery(iface)}, nil
+}
+
+// Ping implements the prober interface. Replies reporting an error are
+// answered requests, the error code is part of the reply.
+func (p *extendedEchoProber) Ping(dst *net.IPAddr, timeout time.Duration, _ extraReplyHandler) (echoReply, error) {
+	return p.pinger.PingInterface(dst, p.query, timeout)
+}
+
+// Close releases the pinger
+func (p *extendedEchoProber) Close() error {
+	return p.pinger.Close()
+}
+
+// parseInterfaceQuery returns the interface identification object for an
+// interface index (digits only), an address or a name
+func parseInterfaceQuery(s string) *icmp.InterfaceIdent {
+	if idx, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return &icmp.InterfaceIdent{Class: classInterfaceIdent, Type: typeInterfaceByIndex, Index: int(idx)}
+	}
+
+	if ip := net.ParseIP(s); ip != nil {
+		if ip.To4() != nil {
+			return &icmp.InterfaceIdent{Class: classInterfaceIdent, Type: typeInterfaceByAddress, AFI: afiIPv4, Addr: ip.To4()}
+		}
+		return &icmp.InterfaceIdent{Class: classInterfaceIdent, Type: typeInterfaceByAddress, AFI: afiIPv6, Addr: ip.To16()}
+	}
+
+	return &icmp.InterfaceIdent{Class: classInterfaceIdent, Type: typeInterfaceByName, Name: s}
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	ip4 "golang.org/x/net/ipv4"
+)
+
+func TestParseInterfaceQuery(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  icmp.InterfaceIdent
+	}{
+		{query: "ge-0/0/1", want: icmp.InterfaceIdent{Type: typeInterfaceByName, Name: "ge-0/0/1"}},
+		{query: "17", want: icmp.InterfaceIdent{Type: typeInterfaceByIndex, Index: 17}},
+		{query: "192.0.2.1", want: icmp.InterfaceIdent{Type: typeInterfaceByAddress, AFI: afiIPv4, Addr: net.IP{192, 0, 2, 1}}},
+		{query: "2001:db8::1", want: icmp.InterfaceIdent{Type: typeInterfaceByAddress, AFI: afiIPv6, Addr: net.ParseIP("2001:db8::1")}},
+	} {
+		tt.want.Class = classInterfaceIdent
+		got := parseInterfaceQuery(tt.query)
+		if got.Class != tt.want.Class || got.Type != tt.want.Type || got.Name != tt.want.Name || got.Index != tt.want.Index ||
+			got.AFI != tt.want.AFI || !net.IP(got.Addr).Equal(tt.want.Addr) {
+			t.Errorf("%s: got %+v, want %+v", tt.query, *got, tt.want)
+		}
+	}
+}
+
+func TestPinger_handleExtendedEchoReply(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	p := &pinger{id: 42, requests: newRequestTable(), interfaceRequests: newRequestTableOfSize(1 << 8)}
+	req, _ := p.interfaceRequests.add(dst, nil, 0, nil)
+	p.interfaceRequests.markSent(req)
+
+	msg := icmp.Message{
+		Type: ip4.ICMPTypeExtendedEchoReply,
+		Code: 0,
+		Body: &icmp.ExtendedEchoReply{ID: 42, Seq: int(req.seq), Active: true, IPv6: true},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p.handleMessage(protocolICMP, b, dst, 255, time.Now())
+
+	reply, err := p.interfaceRequests.wait(req, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (interfaceState{active: true, ipv6: true}); reply.iface == nil || *reply.iface != want {
+		t.Errorf("got state %+v, want %+v", reply.iface, want)
+	}
+}
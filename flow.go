@@ -0,0 +1,114 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// maxFlows limits the flows of a target
+const maxFlows = 64
+
+// flowPinger sends the echo requests of a flow. Routers balancing by the
+// first four bytes of the transport header (the ports of TCP and UDP) hash
+// type, code and checksum of ICMP messages, so the checksum is kept the same
+// for all requests of a flow (like Paris traceroute).
+type flowPinger struct {
+	pinger *sharedPinger
+	flow   int
+}
+
+// newProbers returns a prober per flow of t, a single one if flows are disabled
+func newProbers(t config.TargetConfig, b socketOptions) ([]prober, error) {
+	if t.Flows == 0 {
+		p, err := newProber(t, b)
+		if err != nil {
+			return nil, err
+		}
+		return []prober{p}, nil
+	}
+
+	probers := make([]prober, 0, t.Flows)
+	for i := range t.Flows {
+		p, err := newFlowProber(t, b, i)
+		if err != nil {
+			for _, p := range probers {
+				closeProber(p)
+			}
+			return nil, err
+		}
+		probers = append(probers, p)
+	}
+
+	return probers, nil
+}
+
+// newFlowProber returns the prober of a flow of t. UDP probers use a socket
+// (i.e. source port) of their own for each flow.
+func newFlowProber(t config.TargetConfig, b socketOptions, flow int) (prober, error) {
+	switch t.ProbeType() {
+	case config.ProbeUDPEcho, config.ProbeTWAMPLight:
+		p, err := newUDPProber(t.ProbeType(), t.Port, b.size, b)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	default:
+		p, err := icmpPingers.acquire(b)
+		if err != nil {
+			return nil, err
+		}
+		return &flowPinger{pinger: p, flow: flow}, nil
+	}
+}
+
+// Ping implements the prober interface
+func (p *flowPinger) Ping(dst *net.IPAddr, timeout time.Duration, h extraReplyHandler) (echoReply, error) {
+	return p.pinger.PingFlow(dst, p.flow, timeout, h)
+}
+
+// Close releases the pinger
+func (p *flowPinger) Close() error {
+	return p.pinger.Close()
+}
+
+// balanceFlowChecksum sets the first two bytes of the payload of an echo
+// request, so the sum of the message (and thereby the checksum) only depends
+// on the flow. The payload needs to be at least two bytes long.
+func balanceFlowChecksum(v6 bool, id, seq uint16, payload []byte, flow int) {
+	typ := uint16(8) << 8 // echo request, code 0
+	if v6 {
+		typ = uint16(128) << 8
+	}
+
+	binary.BigEndian.PutUint16(payload, 0)
+	sum := onesSum(onesSum(onesSum(typ, id), seq), wordSum(payload))
+
+	// the sum of the flow is never 0 or 0xffff, which are the same in ones' complement
+	want := uint16(flow + 1)
+	binary.BigEndian.PutUint16(payload, onesSum(want, ^sum))
+}
+
+// onesSum adds a and b in ones' complement arithmetic
+func onesSum(a, b uint16) uint16 {
+	s := uint32(a) + uint32(b)
+	return uint16(s&0xffff + s>>16)
+}
+
+// wordSum returns the ones' complement sum of the 16 bit words of b, an
+// odd length is padded with zero
+func wordSum(b []byte) uint16 {
+	var s uint16
+	for i := 0; i+1 < len(b); i += 2 {
+		s = onesSum(s, binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s = onesSum(s, uint16(b[len(b)-1])<<8)
+	}
+
+	return s
+}
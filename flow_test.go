@@ -0,0 +1,41 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"golang.org/x/net/icmp"
+	ip4 "golang.org/x/net/ipv4"
+)
+
+func TestBalanceFlowChecksum(t *testing.T) {
+	checksums := make(map[uint16]int)
+
+	for flow := range 3 {
+		for seq := range uint16(5) {
+			payload := []byte{0, 0, 0x7e, 0x7d, 0x7e, 0x7d, 0x7e}
+			balanceFlowChecksum(false, 4242, 65533+seq, payload, flow)
+
+			msg := icmp.Message{
+				Type: ip4.ICMPTypeEcho,
+				Body: &icmp.Echo{ID: 4242, Seq: int(65533 + seq), Data: payload},
+			}
+			b, err := msg.Marshal(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sum := binary.BigEndian.Uint16(b[2:])
+			if f, found := checksums[sum]; found && f != flow {
+				t.Fatalf("flows %d and %d have the same checksum %#04x", f, flow, sum)
+			}
+			checksums[sum] = flow
+		}
+	}
+
+	if len(checksums) != 3 {
+		t.Errorf("expected a checksum per flow, got %v", checksums)
+	}
+}
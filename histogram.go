@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// histogramLayout describes the buckets of a RTT histogram
+type histogramLayout struct {
+	buckets            []float64
+	nativeBucketFactor float64
+}
+
+func (l histogramLayout) equal(o histogramLayout) bool {
+	return slices.Equal(l.buckets, o.buckets) && l.nativeBucketFactor == o.nativeBucketFactor
+}
+
+func newRTTHistogram(layout histogramLayout) prometheus.Histogram {
+	return prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:                        "rtt_seconds",
+		Buckets:                     layout.buckets,
+		NativeHistogramBucketFactor: layout.nativeBucketFactor,
+	})
+}
+
+// labeledHistogram exports a histogram with the variable labels of desc
+type labeledHistogram struct {
+	desc       *prometheus.Desc
+	histogram  prometheus.Histogram
+	labelPairs []*dto.LabelPair
+}
+
+func newLabeledHistogram(desc *prometheus.Desc, h prometheus.Histogram, labelValues ...string) prometheus.Metric {
+	return &labeledHistogram{
+		desc:       desc,
+		histogram:  h,
+		labelPairs: prometheus.MakeLabelPairs(desc, labelValues),
+	}
+}
+
+func (h *labeledHistogram) Desc() *prometheus.Desc {
+	return h.desc
+}
+
+func (h *labeledHistogram) Write(m *dto.Metric) error {
+	if err := h.histogram.Write(m); err != nil {
+		return err
+	}
+
+	m.Label = h.labelPairs
+	return nil
+}
+
+// validateHistogramConfig checks the global and per target bucket layouts,
+// since creating a histogram with unsorted buckets panics. Native bucket
+// factors below 1 would silently disable the native histogram.
+func validateHistogramConfig(cfg *config.Config) error {
+	if !isStrictlyIncreasing(cfg.Metrics.RTTBuckets) {
+		return fmt.Errorf("metrics.rtt-buckets must be in increasing order")
+	}
+	if cfg.Metrics.RTTNativeBucketFactor < 1 {
+		return fmt.Errorf("metrics.rtt-native-bucket-factor must be at least 1")
+	}
+
+	for _, t := range cfg.Targets {
+		if !isStrictlyIncreasing(t.RTTBuckets) {
+			return fmt.Errorf("rtt-buckets of target %s must be in increasing order", t.Addr)
+		}
+		if t.RTTNativeBucketFactor != 0 && t.RTTNativeBucketFactor < 1 {
+			return fmt.Errorf("rtt-native-bucket-factor of target %s must be at least 1", t.Addr)
+		}
+	}
+
+	return nil
+}
+
+func isStrictlyIncreasing(values []float64) bool {
+	for i := 1; i < len(values); i++ {
+		if values[i] <= values[i-1] {
+			return false
+		}
+	}
+
+	return true
+}
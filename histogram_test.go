@@ -0,0 +1,76 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestValidateHistogramConfig(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		buckets []float64
+		factor  float64
+		target  config.TargetConfig
+		wantErr bool
+	}{
+		{name: "valid", buckets: []float64{0.01, 0.1, 1}, factor: 1.1},
+		{name: "native disabled", buckets: []float64{0.01}, factor: 1},
+		{name: "unsorted buckets", buckets: []float64{0.1, 0.01}, factor: 1.1, wantErr: true},
+		{name: "duplicate buckets", buckets: []float64{0.1, 0.1}, factor: 1.1, wantErr: true},
+		{name: "factor below 1", buckets: []float64{0.01}, factor: 0.5, wantErr: true},
+		{name: "unsorted target buckets", factor: 1.1, target: config.TargetConfig{Addr: "192.0.2.1", RTTBuckets: []float64{1, 0.5}}, wantErr: true},
+		{name: "target factor below 1", factor: 1.1, target: config.TargetConfig{Addr: "192.0.2.1", RTTNativeBucketFactor: 0.9}, wantErr: true},
+		{name: "target overrides", factor: 1.1, target: config.TargetConfig{Addr: "192.0.2.1", RTTBuckets: []float64{0.5, 1}, RTTNativeBucketFactor: 1.5}},
+	} {
+		cfg := &config.Config{}
+		cfg.Metrics.RTTBuckets = tt.buckets
+		cfg.Metrics.RTTNativeBucketFactor = tt.factor
+		cfg.Targets = []config.TargetConfig{tt.target}
+
+		if err := validateHistogramConfig(cfg); (err != nil) != tt.wantErr {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestLabeledHistogram(t *testing.T) {
+	desc := prometheus.NewDesc("ping_rtt_seconds", "", []string{"target", "ip"}, nil)
+
+	for _, tt := range []struct {
+		factor     float64
+		wantNative bool
+	}{
+		{factor: 1},
+		{factor: 1.1, wantNative: true},
+	} {
+		h := newRTTHistogram(histogramLayout{buckets: []float64{0.01, 0.1}, nativeBucketFactor: tt.factor})
+		h.Observe(0.005)
+		h.Observe(0.05)
+		h.Observe(0.5)
+
+		var m dto.Metric
+		if err := newLabeledHistogram(desc, h, "example.com", "192.0.2.1").Write(&m); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(m.Label) != 2 || m.Label[0].GetName() != "ip" || m.Label[0].GetValue() != "192.0.2.1" ||
+			m.Label[1].GetName() != "target" || m.Label[1].GetValue() != "example.com" {
+			t.Errorf("unexpected labels %v", m.Label)
+		}
+
+		hist := m.GetHistogram()
+		if hist.GetSampleCount() != 3 || len(hist.Bucket) != 2 ||
+			hist.Bucket[0].GetCumulativeCount() != 1 || hist.Bucket[1].GetCumulativeCount() != 2 {
+			t.Errorf("factor %v: unexpected histogram %v", tt.factor, hist)
+		}
+		if native := hist.Schema != nil; native != tt.wantNative {
+			t.Errorf("factor %v: expected native histogram %v, got %v", tt.factor, tt.wantNative, native)
+		}
+	}
+}
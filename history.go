@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+// result stores the outcome of a single echo request
+type result struct {
+	rtt  time.Duration
+	lost bool
+}
+
+// history keeps the last results of a target
+type history struct {
+	results  []result
+	count    int
+	position int
+	updated  time.Time
+	mutex    sync.RWMutex
+
+	// running interarrival jitter estimate according to RFC 3550, section 6.4.1
+	jitter     float64
+	jitterInit bool
+	lastRTT    time.Duration
+	hasLastRTT bool
+}
+
+func newHistory(capacity int) *history {
+	return &history{
+		results: make([]result, capacity),
+	}
+}
+
+func (h *history) add(rtt time.Duration, err error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	h.results[h.position] = result{rtt: rtt, lost: err != nil}
+	h.position = (h.position + 1) % len(h.results)
+
+	if h.count < len(h.results) {
+		h.count++
+	}
+	h.updated = time.Now()
+
+	if err == nil {
+		h.updateJitter(rtt)
+	}
+}
+
+// updateJitter applies J(i) = J(i-1) + (|D(i-1,i)| - J(i-1))/16 with the
+// difference of consecutive RTTs as D
+func (h *history) updateJitter(rtt time.Duration) {
+	if h.hasLastRTT {
+		d := math.Abs(float64(rtt - h.lastRTT))
+		h.jitter += (d - h.jitter) / 16
+		h.jitterInit = true
+	}
+
+	h.lastRTT = rtt
+	h.hasLastRTT = true
+}
+
+// compute aggregates the history into metrics, returns nil if the history is empty
+func (h *history) compute() *resultMetrics {
+	h.mutex.RLock()
+	defer h.mutex.RUnlock()
+
+	if h.count == 0 {
+		return nil
+	}
+
+	m := &resultMetrics{
+		PacketsSent: h.count,
+		Updated:     h.updated,
+		rtts:        make([]float32, 0, h.count),
+	}
+
+	var sumDiffs float64
+	var last float32
+	start := (h.position - h.count + len(h.results)) % len(h.results)
+	for i := range h.count {
+		r := h.results[(start+i)%len(h.results)]
+		if r.lost {
+			m.PacketsLost++
+			continue
+		}
+
+		rtt := durationToMillis(r.rtt)
+		if len(m.rtts) > 0 {
+			sumDiffs += math.Abs(float64(rtt - last))
+		}
+		last = rtt
+		m.rtts = append(m.rtts, rtt)
+	}
+
+	m.JitterRFC3550 = float32(math.NaN())
+	if h.jitterInit {
+		m.JitterRFC3550 = durationToMillis(time.Duration(h.jitter))
+	}
+	m.JitterConsecutive = float32(math.NaN())
+	if len(m.rtts) > 1 {
+		m.JitterConsecutive = float32(sumDiffs / float64(len(m.rtts)-1))
+	}
+
+	m.aggregate()
+	return m
+}
+
+// resultMetrics is a data point computed from the history of a target. All
+// times are in millis.
+type resultMetrics struct {
+	PacketsSent int
+	PacketsLost int
+	Best        float32
+	Worst       float32
+	Mean        float32
+	StdDev      float32
+	Updated     time.Time     // time of the latest result
+	StaleAfter  time.Duration // age of the latest result the target is considered stale after
+
+	JitterRFC3550     float32 // smoothed interarrival jitter (RFC 3550)
+	JitterConsecutive float32 // mean absolute difference of consecutive RTTs
+
+	Hops []hopMetrics // nil if the target is not traced
+
+	rtts []float32 // RTTs of the received replies, sorted ascending
+}
+
+func (m *resultMetrics) aggregate() {
+	if len(m.rtts) == 0 {
+		return
+	}
+
+	var total float64
+	for _, rtt := range m.rtts {
+		total += float64(rtt)
+	}
+	mean := total / float64(len(m.rtts))
+
+	var sumSquares float64
+	for _, rtt := range m.rtts {
+		diff := float64(rtt) - mean
+		sumSquares += diff * diff
+	}
+
+	slices.Sort(m.rtts)
+	m.Best = m.rtts[0]
+	m.Worst = m.rtts[len(m.rtts)-1]
+	m.Mean = float32(mean)
+	m.StdDev = float32(math.Sqrt(sumSquares / float64(len(m.rtts))))
+}
+
+// quantile returns the q-quantile of the RTTs, interpolating linearly
+// between the closest ranks
+func (m *resultMetrics) quantile(q float64) float32 {
+	if len(m.rtts) == 0 {
+		return float32(math.NaN())
+	}
+
+	pos := q * float64(len(m.rtts)-1)
+	lower := int(math.Floor(pos))
+	upper := int(math.Ceil(pos))
+	frac := float32(pos - float64(lower))
+
+	return m.rtts[lower] + (m.rtts[upper]-m.rtts[lower])*frac
+}
+
+func durationToMillis(d time.Duration) float32 {
+	return float32(float64(d) / float64(time.Millisecond))
+}
@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestHistory_compute(t *testing.T) {
+	h := newHistory(4)
+	if h.compute() != nil {
+		t.Fatal("expected nil metrics for empty history")
+	}
+
+	timeout := errors.New("i/o timeout")
+	h.add(400*time.Millisecond, nil)
+	h.add(0, timeout)
+	h.add(100*time.Millisecond, nil)
+	h.add(300*time.Millisecond, nil)
+	h.add(200*time.Millisecond, nil) // overwrites the oldest result
+
+	m := h.compute()
+	if m.PacketsSent != 4 || m.PacketsLost != 1 {
+		t.Errorf("expected 4 sent and 1 lost, got %d and %d", m.PacketsSent, m.PacketsLost)
+	}
+	if m.Best != 100 || m.Worst != 300 || m.Mean != 200 {
+		t.Errorf("expected best=100 worst=300 mean=200, got %v %v %v", m.Best, m.Worst, m.Mean)
+	}
+	if d := math.Abs(float64(m.StdDev) - 81.6497); d > 0.001 {
+		t.Errorf("expected std dev of 81.6497, got %v", m.StdDev)
+	}
+}
+
+func TestResultMetrics_quantile(t *testing.T) {
+	m := &resultMetrics{rtts: []float32{10, 20, 30, 40}}
+
+	tests := []struct {
+		q    float64
+		want float32
+	}{
+		{0, 10},
+		{0.5, 25},
+		{0.9, 37},
+		{1, 40},
+	}
+	for _, tt := range tests {
+		if got := m.quantile(tt.q); math.Abs(float64(got-tt.want)) > 0.0001 {
+			t.Errorf("quantile(%v) = %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	empty := &resultMetrics{}
+	if got := empty.quantile(0.5); !math.IsNaN(float64(got)) {
+		t.Errorf("expected NaN for empty metrics, got %v", got)
+	}
+}
+
+func TestHistory_jitter(t *testing.T) {
+	h := newHistory(10)
+	h.add(10*time.Millisecond, nil)
+
+	m := h.compute()
+	if !math.IsNaN(float64(m.JitterRFC3550)) || !math.IsNaN(float64(m.JitterConsecutive)) {
+		t.Errorf("expected no jitter for a single reply, got %v and %v", m.JitterRFC3550, m.JitterConsecutive)
+	}
+
+	h.add(26*time.Millisecond, nil)
+	h.add(0, errors.New("i/o timeout"))
+	h.add(10*time.Millisecond, nil)
+
+	m = h.compute()
+	if m.JitterConsecutive != 16 {
+		t.Errorf("expected consecutive jitter of 16, got %v", m.JitterConsecutive)
+	}
+	// J1 = 16/16 = 1, J2 = 1 + (16-1)/16 = 1.9375
+	if d := math.Abs(float64(m.JitterRFC3550) - 1.9375); d > 0.0001 {
+		t.Errorf("expected RFC 3550 jitter of 1.9375, got %v", m.JitterRFC3550)
+	}
+}
@@ -0,0 +1,94 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/icmp"
+	ip4 "golang.org/x/net/ipv4"
+	ip6 "golang.org/x/net/ipv6"
+)
+
+// icmpError is an ICMP error message (e.g. destination unreachable) received
+// in response to a probe
+type icmpError struct {
+	typ      icmp.Type
+	code     int
+	reporter net.IP // sender of the message, the target or a router on the path
+}
+
+// sendError is a local error sending a probe, e.g. no route to host
+type sendError struct {
+	err error
+}
+
+func (e *icmpError) Error() string {
+	return fmt.Sprintf("%s (code %d) from %s", e.typ, e.code, e.reporter)
+}
+
+// Is reports fragmentation needed (IPv4) and packet too big (IPv6) as
+// errTooBig and time exceeded as errTTLExceeded
+func (e *icmpError) Is(target error) bool {
+	switch target {
+	case errTooBig:
+		return e.typ == ip6.ICMPTypePacketTooBig || e.typ == ip4.ICMPTypeDestinationUnreachable && e.code == 4
+	case errTTLExceeded:
+		return e.typ == ip4.ICMPTypeTimeExceeded || e.typ == ip6.ICMPTypeTimeExceeded
+	default:
+		return false
+	}
+}
+
+// typeLabel returns the name of the type in snake case (e.g.
+// destination_unreachable), the number if unknown
+func (e *icmpError) typeLabel() string {
+	if s := fmt.Sprint(e.typ); s != "<nil>" {
+		return strings.ReplaceAll(s, " ", "_")
+	}
+
+	switch t := e.typ.(type) {
+	case ip4.ICMPType:
+		return strconv.Itoa(int(t))
+	case ip6.ICMPType:
+		return strconv.Itoa(int(t))
+	default:
+		return ""
+	}
+}
+
+func (e *sendError) Error() string {
+	return e.err.Error()
+}
+
+func (e *sendError) Unwrap() error {
+	return e.err
+}
+
+// icmpErrorType returns the ICMP type of the given number
+func icmpErrorType(v6 bool, typ int) icmp.Type {
+	if v6 {
+		return ip6.ICMPType(typ)
+	}
+
+	return ip4.ICMPType(typ)
+}
+
+// errorMessageData returns the start of the packet an ICMP error message refers to
+func errorMessageData(body icmp.MessageBody) []byte {
+	switch b := body.(type) {
+	case *icmp.DstUnreach:
+		return b.Data
+	case *icmp.TimeExceeded:
+		return b.Data
+	case *icmp.PacketTooBig:
+		return b.Data
+	case *icmp.ParamProb:
+		return b.Data
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/net/icmp"
+	ip4 "golang.org/x/net/ipv4"
+	ip6 "golang.org/x/net/ipv6"
+)
+
+func TestICMPError(t *testing.T) {
+	for _, tt := range []struct {
+		err        *icmpError
+		label      string
+		tooBig     bool
+		ttlExpired bool
+	}{
+		{err: &icmpError{typ: ip4.ICMPTypeDestinationUnreachable, code: 13}, label: "destination_unreachable"},
+		{err: &icmpError{typ: ip4.ICMPTypeDestinationUnreachable, code: 4}, label: "destination_unreachable", tooBig: true},
+		{err: &icmpError{typ: ip6.ICMPTypePacketTooBig}, label: "packet_too_big", tooBig: true},
+		{err: &icmpError{typ: ip4.ICMPTypeTimeExceeded}, label: "time_exceeded", ttlExpired: true},
+		{err: &icmpError{typ: ip6.ICMPTypeTimeExceeded}, label: "time_exceeded", ttlExpired: true},
+		{err: &icmpError{typ: ip4.ICMPType(44)}, label: "44"},
+	} {
+		if got := tt.err.typeLabel(); got != tt.label {
+			t.Errorf("%v: expected label %q, got %q", tt.err.typ, tt.label, got)
+		}
+		if got := errors.Is(tt.err, errTooBig); got != tt.tooBig {
+			t.Errorf("%v (code %d): expected too big %v, got %v", tt.err.typ, tt.err.code, tt.tooBig, got)
+		}
+		if got := errors.Is(tt.err, errTTLExceeded); got != tt.ttlExpired {
+			t.Errorf("%v: expected TTL exceeded %v, got %v", tt.err.typ, tt.ttlExpired, got)
+		}
+	}
+}
+
+func TestTargetStats_countError(t *testing.T) {
+	var st targetStats
+	unreachable := &icmpError{typ: ip4.ICMPTypeDestinationUnreachable, code: 1, reporter: net.ParseIP("198.51.100.1")}
+
+	st.countError(errTimeout)
+	st.countError(&sendError{errors.New("no route to host")})
+	st.countError(unreachable)
+	st.countError(unreachable)
+	st.countError(dialError(&net.OpError{Op: "dial", Err: syscall.EHOSTUNREACH}))
+	st.countError(&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED})
+	st.countError(errors.New("unknown"))
+
+	if st.timeouts != 1 || st.sendErrors != 2 || st.connectionsRefused != 1 {
+		t.Errorf("expected 1 timeout, 2 send errors and 1 refused connection, got %d, %d and %d", st.timeouts, st.sendErrors, st.connectionsRefused)
+	}
+	if n := st.icmpErrors[icmpErrorKey{"destination_unreachable", 1, "198.51.100.1"}]; n != 2 || len(st.icmpErrors) != 1 {
+		t.Errorf("unexpected ICMP errors %v", st.icmpErrors)
+	}
+}
+
+func TestPinger_queuedErrorHandler(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	router := net.ParseIP("198.51.100.1")
+	p := &pinger{datagram: true, requests: newRequestTable()}
+	handle := p.queuedErrorHandler(protocolICMP)
+
+	// the error queue passes the first 64 bytes of the echo request, the
+	// identifier is set by the kernel
+	echoRequest := func(req *echoRequest) []byte {
+		msg := icmp.Message{Type: ip4.ICMPTypeEcho, Body: &icmp.Echo{ID: 1234, Seq: int(req.seq), Data: make([]byte, 56)}}
+		b, err := msg.Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b[:64]
+	}
+
+	unreachable, _ := p.requests.add(dst, nil, 0, nil)
+	p.requests.markSent(unreachable)
+	handle(echoRequest(unreachable), &icmpError{typ: ip4.ICMPTypeDestinationUnreachable, code: 1, reporter: router})
+	var e *icmpError
+	if _, err := p.requests.wait(unreachable, time.Second); !errors.As(err, &e) || e.code != 1 {
+		t.Errorf("expected destination unreachable, got %v", err)
+	}
+
+	traced, _ := p.requests.add(dst, nil, 1, nil)
+	p.requests.markSent(traced)
+	handle(echoRequest(traced), &icmpError{typ: ip4.ICMPTypeTimeExceeded, reporter: router})
+	reply, err := p.requests.wait(traced, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.hop.Equal(router) {
+		t.Errorf("expected hop %s, got %s", router, reply.hop)
+	}
+}
@@ -0,0 +1,74 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dnsLookupDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "ping_dns_lookup_duration_seconds",
+		Help:    "Duration of the DNS lookups of the targets in seconds",
+		Buckets: []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10},
+	})
+	dnsLookupErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ping_dns_lookup_errors_total",
+		Help: "Number of failed DNS lookups",
+	}, []string{"target"})
+	configReloadSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ping_config_reload_success",
+		Help: "Whether the latest config reload was successful (1) or not (0)",
+	})
+	configLastReload = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "ping_config_last_reload_timestamp_seconds",
+		Help: "Unix timestamp of the latest successful config (re)load",
+	})
+)
+
+// configLoaded updates the config reload metrics
+func configLoaded(success bool) {
+	if !success {
+		configReloadSuccess.Set(0)
+		return
+	}
+
+	configReloadSuccess.Set(1)
+	configLastReload.SetToCurrentTime()
+}
+
+// inventoryCollector exports the number of configured targets and resolved addresses
+type inventoryCollector struct {
+	targets       *targets
+	targetsDesc   *prometheus.Desc
+	addressesDesc *prometheus.Desc
+}
+
+func newInventoryCollector(t *targets) *inventoryCollector {
+	return &inventoryCollector{
+		targets:       t,
+		targetsDesc:   newDesc("targets", "Number of configured targets", nil, nil),
+		addressesDesc: newDesc("target_addresses", "Number of resolved target addresses", []string{"ip_version"}, nil),
+	}
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.targetsDesc
+	ch <- c.addressesDesc
+}
+
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	targets := c.targets.Targets()
+	ch <- prometheus.MustNewConstMetric(c.targetsDesc, prometheus.GaugeValue, float64(len(targets)))
+
+	count := map[ipVersion]int{ipv4: 0, ipv6: 0}
+	for _, t := range targets {
+		for _, addr := range t.Addresses() {
+			count[getIPVersion(addr)]++
+		}
+	}
+
+	for v, n := range count {
+		ch <- prometheus.MustNewConstMetric(c.addressesDesc, prometheus.GaugeValue, float64(n), v.String())
+	}
+}
@@ -13,9 +13,6 @@ import (
 	"sync"
 	"time"
 
-	"github.com/digineo/go-ping"
-	mon "github.com/digineo/go-ping/monitor"
-
 	"github.com/czerwonk/ping_exporter/config"
 
 	"github.com/alecthomas/kingpin/v2"
@@ -55,15 +52,35 @@ var (
 	pingInterval            = kingpin.Flag("ping.interval", "Interval for ICMP echo requests").Default("5s").Duration()
 	pingTimeout             = kingpin.Flag("ping.timeout", "Timeout for ICMP echo request").Default("4s").Duration()
 	pingSize                = kingpin.Flag("ping.size", "Payload size for ICMP echo requests").Default("56").Uint16()
+	payloadPattern          = kingpin.Flag("ping.payload-pattern", "Pattern the payload of ICMP and UDP echo requests is filled with: random, zeros, ones (0xFF) or dsl (0x7E 0x7D)").Default("random").String()
 	firewallMark            = kingpin.Flag("ping.fw-mark", "set socket mark (SO_MARK) to this value").Default("0").Uint()
-	historySize             = kingpin.Flag("ping.history-size", "Number of results to remember per target").Default("10").Int()
+	sourceAddress           = kingpin.Flag("ping.source-address", "Source address of the probes").Default("").String()
+	bindInterface           = kingpin.Flag("ping.interface", "Network interface to send the probes from (SO_BINDTODEVICE)").Default("").String()
+	bindVRF                 = kingpin.Flag("ping.vrf", "VRF to send the probes from").Default("").String()
+	pingDSCP                = kingpin.Flag("ping.dscp", "DSCP value (0-63) to mark the probes with").Default("0").Uint8()
+	pingTOS                 = kingpin.Flag("ping.tos", "Raw TOS / traffic class byte to mark the probes with (exclusive with ping.dscp)").Default("0").Uint8()
+	unprivileged            = kingpin.Flag("ping.unprivileged", "Use unprivileged ICMP sockets (SOCK_DGRAM) permitted by sysctl net.ipv4.ping_group_range instead of raw sockets").Default().Bool()
+	burstCount              = kingpin.Flag("ping.burst-count", "Number of echo requests sent per interval to each target (burst)").Default("1").Int()
+	burstSpacing            = kingpin.Flag("ping.burst-spacing", "Time between the echo requests of a burst").Default("100ms").Duration()
+	historySize             = kingpin.Flag("ping.history-size", "Number of results (bursts if ping.burst-count is greater than 1) to remember per target").Default("10").Int()
+	quantiles               = kingpin.Flag("ping.quantiles", "Quantile of the round trip times in the history to export, e.g. 0.9 (repeatable)").Float64List()
+	rttBuckets              = kingpin.Flag("metrics.rtt-buckets", "Bucket boundaries in seconds of the ping_rtt_seconds histogram (repeatable)").Default("0.0005", "0.001", "0.0025", "0.005", "0.01", "0.025", "0.05", "0.1", "0.25", "0.5", "1", "2.5", "5").Float64List()
+	rttNativeBucketFactor   = kingpin.Flag("metrics.rtt-native-bucket-factor", "Growth factor of the native ping_rtt_seconds histogram buckets (1 disables the native histogram)").Default("1.1").Float64()
+	traceMaxHops            = kingpin.Flag("trace.max-hops", "Highest TTL (or hop limit) probed by traces").Default("30").Int()
+	traceMaxHopAddresses    = kingpin.Flag("trace.max-hop-addresses", "Number of responding addresses of a hop exported by traces, limits the cardinality of ping_hop_rtt_seconds").Default("3").Int()
+	staleAfter              = kingpin.Flag("metrics.stale-after", "Drop the series of a target if its latest result is older than this (0 for 3 times the interval plus the timeout of the target)").Default("0s").Duration()
 	dnsRefresh              = kingpin.Flag("dns.refresh", "Interval for refreshing DNS records and updating targets accordingly (0 if disabled)").Default("1m").Duration()
 	dnsNameServer           = kingpin.Flag("dns.nameserver", "DNS server used to resolve hostname of targets").Default("").String()
 	dnsLookupTimeout        = kingpin.Flag("dns.timeout", "Timeout for DNS resolution").Default("0s").Duration()
 	disableIPv6             = kingpin.Flag("options.disable-ipv6", "Disable DNS from resolving IPv6 AAAA records").Default().Bool()
 	disableIPv4             = kingpin.Flag("options.disable-ipv4", "Disable DNS from resolving IPv4 A records").Default().Bool()
 	logLevel                = kingpin.Flag("log.level", "Only log messages with the given severity or above. Valid levels: [debug, info, warn, error, fatal]").Default("info").String()
-	targetFlag              = kingpin.Arg("targets", "A list of targets to ping").Strings()
+	exporterCmd             = kingpin.Command("exporter", "Run the exporter (default)").Default()
+	targetFlag              = exporterCmd.Arg("targets", "A list of targets to ping").Strings()
+	reflectorCmd            = kingpin.Command("reflector", "Answer UDP echo and TWAMP-Light probes of other exporters")
+	reflectorEchoAddress    = reflectorCmd.Flag("reflector.echo-address", "Address to answer UDP echo (RFC 862) packets on, e.g. :7 (empty to disable)").String()
+	reflectorTWAMPAddress   = reflectorCmd.Flag("reflector.twamp-light-address", "Address to answer TWAMP-Light (RFC 5357) test packets on, e.g. :862 (empty to disable)").String()
+	reflectorAllowedPeers   = reflectorCmd.Flag("reflector.allowed-peer", "Prefix of the peers to answer, e.g. 192.0.2.0/24 (repeatable, at least one required)").Strings()
 )
 
 var (
@@ -77,7 +94,7 @@ var (
 
 func main() {
 	desiredTargets = &targets{}
-	kingpin.Parse()
+	cmd := kingpin.Parse()
 
 	if *showVersion {
 		printVersion()
@@ -87,6 +104,13 @@ func main() {
 	setLogLevel(*logLevel)
 	log.SetReportCaller(true)
 
+	if cmd == reflectorCmd.FullCommand() {
+		if err := runReflector(*reflectorEchoAddress, *reflectorTWAMPAddress, *reflectorAllowedPeers); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	switch *deprecatedMetrics {
 	case "enable":
 		enableDeprecatedMetrics = true
@@ -100,6 +124,9 @@ func main() {
 		kingpin.FatalUsage("metrics.rttunit must be `ms` for millis, or `s` for seconds, or `both`")
 	}
 	log.Infof("rtt units: %#v", rttMetricsScale)
+	if enableDeprecatedMetrics && rttMetricsScale == rttInSeconds {
+		log.Warnln("deprecated metrics are only exported in millis, ping_rtt_seconds is the RTT histogram")
+	}
 
 	if mpath := *metricsPath; mpath == "" {
 		log.Warnln("web.telemetry-path is empty, correcting to `/metrics`")
@@ -115,32 +142,86 @@ func main() {
 		kingpin.FatalUsage("could not load config.path: %v", err)
 	}
 
+	if len(cfg.Targets) == 0 {
+		kingpin.FatalUsage("No targets specified")
+	}
+
+	if err := validateConfig(cfg); err != nil {
+		kingpin.FatalUsage("%v", err)
+	}
+
+	runExporter(cfg)
+}
+
+// validateConfig checks the settings at startup and before a reload
+func validateConfig(cfg *config.Config) error {
 	if cfg.Ping.History < 1 {
-		kingpin.FatalUsage("ping.history-size must be greater than 0")
+		return fmt.Errorf("ping.history-size must be greater than 0")
+	}
+
+	if cfg.Ping.BurstCount < 1 {
+		return fmt.Errorf("ping.burst-count must be greater than 0")
+	}
+
+	if cfg.Ping.BurstSpacing < 0 {
+		return fmt.Errorf("ping.burst-spacing must not be negative")
 	}
 
 	if cfg.Ping.Size > 65500 {
-		kingpin.FatalUsage("ping.size must be between 0 and 65500")
+		return fmt.Errorf("ping.size must be between 0 and 65500")
 	}
 
-	if len(cfg.Targets) == 0 {
-		kingpin.FatalUsage("No targets specified")
+	for _, q := range cfg.Ping.Quantiles {
+		if q < 0 || q > 1 {
+			return fmt.Errorf("ping.quantiles must be between 0 and 1")
+		}
 	}
 
-	runExporter(cfg)
+	if cfg.Trace.MaxHops < 1 || cfg.Trace.MaxHops > 255 {
+		return fmt.Errorf("trace.max-hops must be between 1 and 255")
+	}
+
+	if cfg.Trace.MaxHopAddresses < 1 {
+		return fmt.Errorf("trace.max-hop-addresses must be greater than 0")
+	}
+
+	if err := validateHistogramConfig(cfg); err != nil {
+		return err
+	}
+	if err := validateProbeConfig(cfg); err != nil {
+		return err
+	}
+
+	return validateSocketConfig(cfg)
+}
+
+// validateReload rejects changes of the settings which are only applied at
+// startup, all other settings are applied by reloading the config
+func validateReload(startup, cfg *config.Config) error {
+	switch {
+	case cfg.Ping.Unprivileged != startup.Ping.Unprivileged:
+		return fmt.Errorf("ping.unprivileged can not be changed without restart")
+	case cfg.DNS != startup.DNS:
+		return fmt.Errorf("dns settings can not be changed without restart")
+	case cfg.Options != startup.Options:
+		return fmt.Errorf("options can not be changed without restart")
+	}
+
+	return nil
 }
 
 func runInteractive(cfg *config.Config) {
 	globalResolver := setupGlobalResolver(cfg)
 
-	m, err := startMonitor(cfg, globalResolver)
+	monitors, err := startMonitor(cfg, globalResolver)
 	if err != nil {
 		log.Errorln(err)
 		os.Exit(2)
 	}
 
-	collector := NewPingCollector(enableDeprecatedMetrics, rttMetricsScale, m, cfg)
-	go watchConfig(desiredTargets, globalResolver, m, collector)
+	collector := NewPingCollector(enableDeprecatedMetrics, rttMetricsScale, monitors, cfg)
+	configLoaded(true)
+	go watchConfig(cfg, desiredTargets, globalResolver, monitors, collector)
 
 	startServer(collector)
 }
@@ -152,81 +233,107 @@ func printVersion() {
 	fmt.Println("Metric exporter for go-icmp")
 }
 
-func startMonitor(cfg *config.Config, globalResolver Resolver) (*mon.Monitor, error) {
-	var bind4, bind6 string
-	if ln, err := net.Listen("tcp4", "127.0.0.1:0"); err == nil {
-		// ipv4 enabled
-		if err := ln.Close(); err != nil {
-			return nil, fmt.Errorf("failed to close tcp4 listener: %w", err)
-		}
-		bind4 = "0.0.0.0"
-	}
-	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
-		// ipv6 enabled
-		if err := ln.Close(); err != nil {
-			return nil, fmt.Errorf("failed to close tcp6 listener: %w", err)
-		}
-		bind6 = "::"
-	}
-	pinger, err := ping.New(bind4, bind6)
-	if err != nil {
-		return nil, fmt.Errorf("cannot start monitoring: %w", err)
-	}
-
-	if pinger.PayloadSize() != cfg.Ping.Size {
-		pinger.SetPayloadSize(cfg.Ping.Size)
-	}
-
-	if cfg.Ping.FirewallMark > 0 {
-		err := pinger.SetMark(cfg.Ping.FirewallMark)
-		if err != nil {
-			return nil, fmt.Errorf("failed to set fwmark: %w", err)
-		}
-	}
+func startMonitor(cfg *config.Config, globalResolver Resolver) (*monitorSet, error) {
+	icmpPingers = newPingerPool(func(b socketOptions) (*pinger, error) {
+		return newBoundPinger(b, cfg)
+	})
 
-	monitor := mon.New(pinger,
-		cfg.Ping.Interval.Duration(),
-		cfg.Ping.Timeout.Duration())
-	monitor.HistorySize = cfg.Ping.History
+	monitors := newMonitorSet()
 
-	err = upsertTargets(desiredTargets, globalResolver, cfg, monitor)
+	err := upsertTargets(desiredTargets, globalResolver, cfg, monitors)
 	if err != nil {
-		log.Fatalln(err)
+		return nil, fmt.Errorf("cannot start monitoring: %w", err)
 	}
 
-	go startDNSAutoRefresh(cfg.DNS.Refresh.Duration(), desiredTargets, monitor, cfg)
-	return monitor, nil
+	go startDNSAutoRefresh(cfg.DNS.Refresh.Duration(), desiredTargets, cfg)
+	return monitors, nil
 }
 
-func upsertTargets(globalTargets *targets, globalResolver Resolver, cfg *config.Config, monitor *mon.Monitor) error {
+func upsertTargets(globalTargets *targets, globalResolver Resolver, cfg *config.Config, monitors *monitorSet) error {
 	oldTargets := globalTargets.Targets()
 	newTargets := make([]*target, len(cfg.Targets))
-	var wg sync.WaitGroup
+
+	// the probers of the targets created so far are released if the config can not be applied
+	var created []*target
+	release := func() {
+		for _, t := range created {
+			for _, p := range t.probers {
+				closeProber(p)
+			}
+		}
+	}
+
 	var err error
 	for i, t := range cfg.Targets {
-		newTarget := globalTargets.Get(t.Addr)
-		if newTarget == nil {
+		newTarget := &target{
+			host:      t.Addr,
+			probe:     t.ProbeType(),
+			port:      t.Port,
+			socket:    socketOptionsOf(t, cfg),
+			monitor:   monitors.get(scheduleOf(t, cfg)),
+			trace:     traceSettingsOf(t, cfg),
+			timestamp: t.Timestamp,
+			query:     t.ProbeInterface,
+			flows:     t.Flows,
+			addresses: make([]net.IPAddr, 0),
+			delay:     time.Duration(10*i) * time.Millisecond,
+		}
+		if existing := globalTargets.Get(newTarget); existing != nil {
+			newTarget = existing
+		} else {
 			resolver := globalResolver
 			// check if there's a 'resolver' label in the target config
 			// and if its set to 'k8s', then use the k8s resolver
 			if r, ok := t.Labels["resolver"]; ok && r == "k8s" {
 				resolver, err = NewK8sResolver()
 				if err != nil {
+					release()
 					return fmt.Errorf("failed to create k8s resolver: %w", err)
 				}
 			}
-			newTarget = &target{
-				host:      t.Addr,
-				addresses: make([]net.IPAddr, 0),
-				delay:     time.Duration(10*i) * time.Millisecond,
-				resolver:  resolver,
+			probers, err := newProbers(t, newTarget.socket)
+			if err != nil {
+				release()
+				return fmt.Errorf("failed to create %s prober for %s: %w", t.ProbeType(), t.Addr, err)
 			}
+			newTarget.probers = probers
+			created = append(created, newTarget)
+			newTarget.resolver = resolver
+			dnsLookupErrors.WithLabelValues(t.Addr)
 		}
 
 		newTargets[i] = newTarget
+	}
+	globalTargets.SetTargets(newTargets)
+
+	// removed first, a target with changed settings keeps the keys of its predecessor
+	removed := removedTargets(oldTargets, globalTargets)
+	var wg sync.WaitGroup
+	for _, removedTarget := range removed {
+		log.Infof("remove target: %s (%s)", removedTarget.host, removedTarget.probe)
+		// waits for the requests in flight, so the probers are closed afterwards
+		wg.Go(func() {
+			removedTarget.removeFromMonitor()
+			for _, p := range removedTarget.probers {
+				closeProber(p)
+			}
+		})
+		if !globalTargets.ContainsHost(removedTarget.host) {
+			dnsLookupErrors.DeleteLabelValues(removedTarget.host)
+		}
+	}
+	wg.Wait()
+
+	// monitors of schedules no longer used by any target
+	inUse := make(map[*monitor]bool)
+	for _, t := range newTargets {
+		inUse[t.monitor] = true
+	}
+	monitors.retain(inUse)
 
+	for _, newTarget := range newTargets {
 		wg.Go(func() {
-			err := newTarget.addOrUpdateMonitor(monitor, targetOpts{
+			err := newTarget.addOrUpdateMonitor(targetOpts{
 				disableIPv4: cfg.Options.DisableIPv4,
 				disableIPv6: cfg.Options.DisableIPv6,
 			}, cfg)
@@ -236,17 +343,11 @@ func upsertTargets(globalTargets *targets, globalResolver Resolver, cfg *config.
 		})
 	}
 	wg.Wait()
-	globalTargets.SetTargets(newTargets)
 
-	removed := removedTargets(oldTargets, globalTargets)
-	for _, removedTarget := range removed {
-		log.Infof("remove target: %s", removedTarget.host)
-		removedTarget.removeFromMonitor(monitor)
-	}
 	return nil
 }
 
-func watchConfig(globalTargets *targets, globalResolver Resolver, monitor *mon.Monitor, collector *pingCollector) {
+func watchConfig(startup *config.Config, globalTargets *targets, globalResolver Resolver, monitors *monitorSet, collector *pingCollector) {
 	watcher, err := inotify.NewWatcher()
 	if err != nil {
 		log.Fatalf("unable to create file watcher: %v", err)
@@ -269,6 +370,7 @@ func watchConfig(globalTargets *targets, globalResolver Resolver, monitor *mon.M
 			cfg, err := loadConfig()
 			if err != nil {
 				log.Errorf("unable to load config: %v", err)
+				configLoaded(false)
 				continue
 			}
 			// We get zero targets if the file was truncated. This happens if an automation tool rewrites
@@ -276,12 +378,24 @@ func watchConfig(globalTargets *targets, globalResolver Resolver, monitor *mon.M
 			if len(cfg.Targets) == 0 {
 				continue
 			}
+			if err := validateConfig(cfg); err != nil {
+				log.Errorf("invalid config: %v", err)
+				configLoaded(false)
+				continue
+			}
+			if err := validateReload(startup, cfg); err != nil {
+				log.Errorf("unable to reload config: %v", err)
+				configLoaded(false)
+				continue
+			}
 			log.Infof("reloading config file %s", *configFile)
-			if err := upsertTargets(globalTargets, globalResolver, cfg, monitor); err != nil {
+			if err := upsertTargets(globalTargets, globalResolver, cfg, monitors); err != nil {
 				log.Errorf("failed to reload config: %v", err)
+				configLoaded(false)
 				continue
 			}
 			collector.UpdateConfig(cfg)
+			configLoaded(true)
 		case err := <-watcher.Errors:
 			log.Errorf("watching file failed: %v", err)
 		}
@@ -298,21 +412,21 @@ func removedTargets(old []*target, new *targets) []*target {
 	return ret
 }
 
-func startDNSAutoRefresh(interval time.Duration, tar *targets, monitor *mon.Monitor, cfg *config.Config) {
+func startDNSAutoRefresh(interval time.Duration, tar *targets, cfg *config.Config) {
 	if interval <= 0 {
 		return
 	}
 
 	for range time.NewTicker(interval).C {
-		refreshDNS(tar, monitor, cfg)
+		refreshDNS(tar, cfg)
 	}
 }
 
-func refreshDNS(tar *targets, monitor *mon.Monitor, cfg *config.Config) {
+func refreshDNS(tar *targets, cfg *config.Config) {
 	log.Infoln("refreshing DNS")
 	for _, t := range tar.Targets() {
 		go func(ta *target) {
-			err := ta.addOrUpdateMonitor(monitor, targetOpts{
+			err := ta.addOrUpdateMonitor(targetOpts{
 				disableIPv4: cfg.Options.DisableIPv4,
 				disableIPv6: cfg.Options.DisableIPv6,
 			}, cfg)
@@ -337,7 +451,14 @@ func startServer(collector *pingCollector) {
 	})
 
 	reg := prometheus.NewRegistry()
-	reg.MustRegister(collector)
+	reg.MustRegister(
+		collector,
+		newInventoryCollector(desiredTargets),
+		dnsLookupDuration,
+		dnsLookupErrors,
+		configReloadSuccess,
+		configLastReload,
+	)
 
 	l := log.New()
 	l.Level = log.ErrorLevel
@@ -509,9 +630,56 @@ func addFlagToConfig(cfg *config.Config) {
 	if cfg.Ping.Size == 0 {
 		cfg.Ping.Size = *pingSize
 	}
+	if cfg.Ping.Pattern == "" {
+		cfg.Ping.Pattern = *payloadPattern
+	}
 	if cfg.Ping.FirewallMark == 0 {
 		cfg.Ping.FirewallMark = *firewallMark
 	}
+	if cfg.Ping.BurstCount == 0 {
+		cfg.Ping.BurstCount = *burstCount
+	}
+	if cfg.Ping.BurstSpacing == 0 {
+		cfg.Ping.BurstSpacing.Set(*burstSpacing)
+	}
+	if cfg.Ping.SourceAddress == "" {
+		cfg.Ping.SourceAddress = *sourceAddress
+	}
+	if cfg.Ping.Interface == "" {
+		cfg.Ping.Interface = *bindInterface
+	}
+	if cfg.Ping.VRF == "" {
+		cfg.Ping.VRF = *bindVRF
+	}
+	if cfg.Ping.DSCP == nil && cfg.Ping.TOS == nil {
+		if *pingDSCP != 0 {
+			cfg.Ping.DSCP = pingDSCP
+		}
+		if *pingTOS != 0 {
+			cfg.Ping.TOS = pingTOS
+		}
+	}
+	if !cfg.Ping.Unprivileged {
+		cfg.Ping.Unprivileged = *unprivileged
+	}
+	if cfg.Trace.MaxHops == 0 {
+		cfg.Trace.MaxHops = *traceMaxHops
+	}
+	if cfg.Trace.MaxHopAddresses == 0 {
+		cfg.Trace.MaxHopAddresses = *traceMaxHopAddresses
+	}
+	if len(cfg.Ping.Quantiles) == 0 {
+		cfg.Ping.Quantiles = *quantiles
+	}
+	if len(cfg.Metrics.RTTBuckets) == 0 {
+		cfg.Metrics.RTTBuckets = *rttBuckets
+	}
+	if cfg.Metrics.RTTNativeBucketFactor == 0 {
+		cfg.Metrics.RTTNativeBucketFactor = *rttNativeBucketFactor
+	}
+	if cfg.Metrics.StaleAfter == 0 {
+		cfg.Metrics.StaleAfter.Set(*staleAfter)
+	}
 	if cfg.DNS.Refresh == 0 {
 		cfg.DNS.Refresh.Set(*dnsRefresh)
 	}
@@ -0,0 +1,98 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func validConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Ping.Interval.Set(5 * time.Second)
+	cfg.Ping.Timeout.Set(time.Second)
+	cfg.Ping.History = 10
+	cfg.Ping.BurstCount = 1
+	cfg.Trace.MaxHops = 30
+	cfg.Trace.MaxHopAddresses = 1
+	cfg.Metrics.RTTNativeBucketFactor = 1
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com"}}
+
+	return cfg
+}
+
+func TestValidateConfig(t *testing.T) {
+	if err := validateConfig(validConfig()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for name, modify := range map[string]func(*config.Config){
+		"history":   func(c *config.Config) { c.Ping.History = 0 },
+		"burst":     func(c *config.Config) { c.Ping.BurstCount = 0 },
+		"quantiles": func(c *config.Config) { c.Ping.Quantiles = []float64{0.5, 1.5} },
+		"max hops":  func(c *config.Config) { c.Trace.MaxHops = 256 },
+		"buckets":   func(c *config.Config) { c.Metrics.RTTBuckets = []float64{1, 0.5} },
+	} {
+		cfg := validConfig()
+		modify(cfg)
+		if err := validateConfig(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestValidateReload(t *testing.T) {
+	startup := validConfig()
+
+	cfg := validConfig()
+	cfg.Ping.History = 20
+	cfg.Ping.Quantiles = []float64{0.9}
+	cfg.Metrics.StaleAfter.Set(time.Minute)
+	if err := validateReload(startup, cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	for name, modify := range map[string]func(*config.Config){
+		"unprivileged": func(c *config.Config) { c.Ping.Unprivileged = true },
+		"nameserver":   func(c *config.Config) { c.DNS.Nameserver = "192.0.2.53:53" },
+		"options":      func(c *config.Config) { c.Options.DisableIPv6 = true },
+	} {
+		cfg := validConfig()
+		modify(cfg)
+		if err := validateReload(startup, cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestUpsertTargets_releasesProbersOnError(t *testing.T) {
+	prev := icmpPingers
+	defer func() {
+		icmpPingers = prev
+	}()
+
+	dscp1, dscp2 := uint8(10), uint8(20)
+	icmpPingers = newPingerPool(func(o socketOptions) (*pinger, error) {
+		if o.tos == int(dscp2)<<2 {
+			return nil, errors.New("socket failed")
+		}
+		return &pinger{stop: make(chan struct{})}, nil
+	})
+
+	cfg := validConfig()
+	cfg.Targets = []config.TargetConfig{
+		{Addr: "192.0.2.1", TrafficClassConfig: config.TrafficClassConfig{DSCP: &dscp1}},
+		{Addr: "192.0.2.2", TrafficClassConfig: config.TrafficClassConfig{DSCP: &dscp2}},
+	}
+
+	if err := upsertTargets(&targets{}, net.DefaultResolver, cfg, newMonitorSet()); err == nil {
+		t.Fatal("expected error")
+	}
+	if len(icmpPingers.pingers) != 0 {
+		t.Errorf("expected the pingers acquired before the error to be released, got %d", len(icmpPingers.pingers))
+	}
+}
@@ -0,0 +1,474 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replyEvent is an irregularity of an echo reply
+type replyEvent int
+
+const (
+	replyDuplicate replyEvent = iota // reply to an already answered request
+	replyLate                        // reply received after the timeout
+	replyReordered                   // reply received after the reply to a newer request
+)
+
+// resultObserver gets notified about every single echo request result
+type resultObserver interface {
+	observe(key string, reply echoReply, err error)
+
+	// observeReplyEvent is called for irregular replies, for late replies
+	// delay is the time elapsed since the timeout
+	observeReplyEvent(key string, ev replyEvent, delay time.Duration)
+
+	// observePathMTU is called for each finished path MTU discovery
+	observePathMTU(key string, r pathMTUResult)
+
+	// observeBurst is called once all requests of a burst are finished
+	observeBurst(key string, sent, lost int)
+
+	// observeOneWayDelays is called for each answered ICMP timestamp request
+	observeOneWayDelays(key string, d oneWayDelays)
+}
+
+// monitor manages the goroutines sending echo requests to the targets and
+// passes each result on to its observers.
+type monitor struct {
+	HistorySize int // Number of results per target to keep
+
+	interval  time.Duration
+	timeout   time.Duration
+	burst     burstSettings
+	targets   map[string]*monitorTarget
+	observers []resultObserver
+	mutex     sync.RWMutex
+}
+
+// probeOptions are the settings of a target in the monitor
+type probeOptions struct {
+	prober    prober
+	pathMTU   bool // discover the path MTU, the prober has to be a sizedProber
+	timestamp bool // send ICMP timestamp requests to IPv4 addresses, the prober has to be a timestampProber
+	trace     traceSettings
+}
+
+// monitorTarget is the unit of work of a monitor
+type monitorTarget struct {
+	key       string
+	addr      net.IPAddr
+	prober    prober
+	pathMTU   *pathMTUSearch  // nil if disabled
+	timestamp timestampProber // nil if disabled
+	trace     *tracer         // nil if disabled
+	monitor   *monitor
+	history   *history
+	stop      chan struct{}
+	wg        sync.WaitGroup // run and the probes started by it
+
+	// newest sequence number answered, to detect reordering
+	lastSeq    uint16
+	hasLastSeq bool
+	seqMutex   sync.Mutex
+}
+
+// monitorSchedule are the settings of a monitor, targets probed with other
+// settings need another monitor
+type monitorSchedule struct {
+	interval    time.Duration
+	timeout     time.Duration
+	historySize int // number of intervals, i.e. bursts
+	burst       burstSettings
+}
+
+// burstSettings make a monitor send several requests per interval
+type burstSettings struct {
+	count   int           // requests per interval, bursts are disabled if less than 2
+	spacing time.Duration // time between the requests of a burst
+}
+
+// monitorSet runs a monitor per schedule, all of them sharing the observers
+type monitorSet struct {
+	monitors  map[monitorSchedule]*monitor
+	observers []resultObserver
+	mutex     sync.Mutex
+}
+
+func newMonitor(interval, timeout time.Duration) *monitor {
+	return &monitor{
+		HistorySize: 10,
+		interval:    interval,
+		timeout:     timeout,
+		targets:     make(map[string]*monitorTarget),
+	}
+}
+
+func newMonitorSet() *monitorSet {
+	return &monitorSet{
+		monitors: make(map[monitorSchedule]*monitor),
+	}
+}
+
+// get returns the monitor of the schedule, creating it if necessary
+func (s *monitorSet) get(sched monitorSchedule) *monitor {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if m, found := s.monitors[sched]; found {
+		return m
+	}
+
+	m := newMonitor(sched.interval, sched.timeout)
+	m.HistorySize = sched.historySize
+	m.burst = sched.burst
+	for _, o := range s.observers {
+		m.AddObserver(o)
+	}
+	s.monitors[sched] = m
+
+	return m
+}
+
+// retain drops the monitors not contained in inUse, e.g. after the schedule of
+// targets has been changed
+func (s *monitorSet) retain(inUse map[*monitor]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for sched, m := range s.monitors {
+		if !inUse[m] {
+			delete(s.monitors, sched)
+		}
+	}
+}
+
+// AddObserver registers o to be notified about every result of all monitors
+func (s *monitorSet) AddObserver(o resultObserver) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.observers = append(s.observers, o)
+	for _, m := range s.monitors {
+		m.AddObserver(o)
+	}
+}
+
+// Export merges the metrics of all monitors, the keys of the targets are unique
+// across the monitors
+func (s *monitorSet) Export() map[string]*resultMetrics {
+	s.mutex.Lock()
+	monitors := make([]*monitor, 0, len(s.monitors))
+	for _, m := range s.monitors {
+		monitors = append(monitors, m)
+	}
+	s.mutex.Unlock()
+
+	ret := make(map[string]*resultMetrics)
+	for _, m := range monitors {
+		maps.Copy(ret, m.Export())
+	}
+
+	return ret
+}
+
+// AddObserver registers o to be notified about every result
+func (m *monitor) AddObserver(o resultObserver) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.observers = append(m.observers, o)
+}
+
+// AddTargetDelayed starts probing addr with the given options after the
+// delay. An existing target with the same key is replaced.
+func (m *monitor) AddTargetDelayed(key string, addr net.IPAddr, opts probeOptions, delay time.Duration) error {
+	// the requests of the replaced target have to be finished before the new
+	// target reports results for the same key
+	m.RemoveTarget(key)
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	p := opts.prober
+	t := &monitorTarget{
+		key:     key,
+		addr:    addr,
+		prober:  p,
+		monitor: m,
+		history: newHistory(m.HistorySize * max(m.burst.count, 1)),
+		stop:    make(chan struct{}),
+	}
+	if opts.pathMTU {
+		sp, ok := p.(sizedProber)
+		if !ok {
+			return fmt.Errorf("path MTU discovery is not supported by the probe of %s", addr.String())
+		}
+		t.pathMTU = newPathMTUSearch(sp)
+	}
+	if opts.timestamp && addr.IP.To4() != nil {
+		tp, ok := p.(timestampProber)
+		if !ok {
+			return fmt.Errorf("timestamp requests are not supported by the probe of %s", addr.String())
+		}
+		t.timestamp = tp
+	}
+	if opts.trace.maxHops > 0 {
+		tp, ok := p.(ttlProber)
+		if !ok {
+			return fmt.Errorf("tracing is not supported by the probe of %s", addr.String())
+		}
+		t.trace = newTracer(tp, opts.trace, m.HistorySize)
+	}
+	t.wg.Go(func() {
+		t.run(delay)
+	})
+	m.targets[key] = t
+
+	return nil
+}
+
+// RemoveTarget stops monitoring the target with the given key and waits for
+// its requests in flight, no results are reported for the key afterwards
+func (m *monitor) RemoveTarget(key string) {
+	m.mutex.Lock()
+	t, found := m.targets[key]
+	if found {
+		close(t.stop)
+		delete(m.targets, key)
+	}
+	m.mutex.Unlock()
+
+	// the requests notify the observers, which needs the mutex
+	if found {
+		t.wg.Wait()
+	}
+}
+
+// Export calculates the metrics for each monitored target
+func (m *monitor) Export() map[string]*resultMetrics {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	ret := make(map[string]*resultMetrics)
+	for key, t := range m.targets {
+		if metrics := t.history.compute(); metrics != nil {
+			metrics.StaleAfter = 3*m.interval + m.timeout
+			if t.trace != nil {
+				metrics.Hops = t.trace.compute()
+			}
+			ret[key] = metrics
+		}
+	}
+
+	return ret
+}
+
+func (m *monitor) notify(key string, reply echoReply, err error) {
+	m.mutex.RLock()
+	observers := m.observers
+	m.mutex.RUnlock()
+
+	for _, o := range observers {
+		o.observe(key, reply, err)
+	}
+}
+
+func (m *monitor) notifyReplyEvent(key string, ev replyEvent, delay time.Duration) {
+	m.mutex.RLock()
+	observers := m.observers
+	m.mutex.RUnlock()
+
+	for _, o := range observers {
+		o.observeReplyEvent(key, ev, delay)
+	}
+}
+
+func (m *monitor) notifyPathMTU(key string, r pathMTUResult) {
+	m.mutex.RLock()
+	observers := m.observers
+	m.mutex.RUnlock()
+
+	for _, o := range observers {
+		o.observePathMTU(key, r)
+	}
+}
+
+func (m *monitor) notifyBurst(key string, sent, lost int) {
+	m.mutex.RLock()
+	observers := m.observers
+	m.mutex.RUnlock()
+
+	for _, o := range observers {
+		o.observeBurst(key, sent, lost)
+	}
+}
+
+func (m *monitor) notifyOneWayDelays(key string, d oneWayDelays) {
+	m.mutex.RLock()
+	observers := m.observers
+	m.mutex.RUnlock()
+
+	for _, o := range observers {
+		o.observeOneWayDelays(key, d)
+	}
+}
+
+func (t *monitorTarget) run(delay time.Duration) {
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-t.stop:
+			return
+		}
+	}
+
+	tick := time.NewTicker(t.monitor.interval)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-tick.C:
+			if t.monitor.burst.count > 1 {
+				t.wg.Go(t.pingBurst)
+			} else {
+				t.wg.Go(func() {
+					t.ping()
+				})
+			}
+			if t.pathMTU != nil {
+				t.wg.Go(t.probePathMTU)
+			}
+			if t.timestamp != nil {
+				t.wg.Go(t.pingTimestamp)
+			}
+			if t.trace != nil {
+				t.wg.Go(func() {
+					t.trace.round(&t.addr, t.monitor.timeout)
+				})
+			}
+		}
+	}
+}
+
+// ping sends a single request and returns its error
+func (t *monitorTarget) ping() error {
+	reply, err := t.prober.Ping(&t.addr, t.monitor.timeout, t)
+
+	if t.stopped() {
+		// target was removed while waiting for the reply
+		return err
+	}
+
+	if err == nil && t.isReordered(reply.seq) {
+		t.monitor.notifyReplyEvent(t.key, replyReordered, 0)
+	}
+
+	t.history.add(reply.rtt, err)
+	t.monitor.notify(t.key, reply, err)
+
+	return err
+}
+
+// pingBurst sends the requests of a burst spaced by the burst spacing, each
+// of them is a result of its own. The observers are notified about the burst
+// once all requests are finished.
+func (t *monitorTarget) pingBurst() {
+	burst := t.monitor.burst
+
+	var lost atomic.Int32
+	var wg sync.WaitGroup
+	for i := range burst.count {
+		if i > 0 {
+			select {
+			case <-time.After(burst.spacing):
+			case <-t.stop:
+			}
+		}
+		if t.stopped() {
+			break
+		}
+
+		wg.Go(func() {
+			if t.ping() != nil {
+				lost.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+
+	if !t.stopped() {
+		t.monitor.notifyBurst(t.key, burst.count, int(lost.Load()))
+	}
+}
+
+// probePathMTU sends the next request of the path MTU discovery
+func (t *monitorTarget) probePathMTU() {
+	r, finished := t.pathMTU.step(&t.addr, t.monitor.timeout)
+	if !finished || t.stopped() {
+		return
+	}
+
+	t.monitor.notifyPathMTU(t.key, r)
+}
+
+// pingTimestamp sends an ICMP timestamp request, unanswered requests are
+// not counted as lost
+func (t *monitorTarget) pingTimestamp() {
+	d, err := t.timestamp.PingTimestamp(&t.addr, t.monitor.timeout)
+	if err != nil || t.stopped() {
+		return
+	}
+
+	t.monitor.notifyOneWayDelays(t.key, d)
+}
+
+func (t *monitorTarget) stopped() bool {
+	select {
+	case <-t.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// isReordered checks if a newer request has already been answered and
+// updates the newest sequence number otherwise
+func (t *monitorTarget) isReordered(seq uint16) bool {
+	t.seqMutex.Lock()
+	defer t.seqMutex.Unlock()
+
+	// serial number arithmetic, the sequence numbers wrap around
+	if t.hasLastSeq && int16(seq-t.lastSeq) < 0 {
+		return true
+	}
+
+	t.lastSeq = seq
+	t.hasLastSeq = true
+	return false
+}
+
+// duplicateReply implements the extraReplyHandler interface
+func (t *monitorTarget) duplicateReply(time.Duration) {
+	if !t.stopped() {
+		t.monitor.notifyReplyEvent(t.key, replyDuplicate, 0)
+	}
+}
+
+// lateReply implements the extraReplyHandler interface
+func (t *monitorTarget) lateReply(seq uint16, rtt time.Duration) {
+	if t.stopped() {
+		return
+	}
+
+	t.monitor.notifyReplyEvent(t.key, replyLate, rtt-t.monitor.timeout)
+	if t.isReordered(seq) {
+		t.monitor.notifyReplyEvent(t.key, replyReordered, 0)
+	}
+}
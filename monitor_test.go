@@ -0,0 +1,177 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMonitorTarget_isReordered(t *testing.T) {
+	mt := &monitorTarget{}
+
+	for _, tt := range []struct {
+		seq  uint16
+		want bool
+	}{
+		{65534, false},
+		{65535, false},
+		{0, false}, // wrap around
+		{65535, true},
+		{2, false},
+		{1, true},
+	} {
+		if got := mt.isReordered(tt.seq); got != tt.want {
+			t.Errorf("isReordered(%d) = %v, want %v", tt.seq, got, tt.want)
+		}
+	}
+}
+
+func TestMonitorSet_get(t *testing.T) {
+	s := newMonitorSet()
+	s.AddObserver(&pingCollector{})
+
+	fast := monitorSchedule{interval: time.Second, timeout: 500 * time.Millisecond, historySize: 60}
+	slow := monitorSchedule{interval: 30 * time.Second, timeout: 5 * time.Second, historySize: 10}
+
+	m := s.get(fast)
+	if s.get(fast) != m {
+		t.Error("expected the monitor of the same schedule")
+	}
+	if s.get(slow) == m {
+		t.Error("expected another monitor for another schedule")
+	}
+
+	if m.interval != fast.interval || m.timeout != fast.timeout || m.HistorySize != fast.historySize {
+		t.Errorf("unexpected settings of monitor: %v %v %d", m.interval, m.timeout, m.HistorySize)
+	}
+	if len(s.get(slow).observers) != 1 {
+		t.Error("expected observer to be registered")
+	}
+}
+
+// burstFake answers every second request
+type burstFake struct {
+	sent  int
+	mutex sync.Mutex
+}
+
+func (f *burstFake) Ping(*net.IPAddr, time.Duration, extraReplyHandler) (echoReply, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.sent++
+	if f.sent%2 == 0 {
+		return echoReply{}, errTimeout
+	}
+
+	return echoReply{seq: uint16(f.sent), rtt: time.Millisecond}, nil
+}
+
+// burstObserver records the observed bursts
+type burstObserver struct {
+	resultObserver
+	sent, lost int
+}
+
+func (o *burstObserver) observe(string, echoReply, error) {}
+
+func (o *burstObserver) observeBurst(_ string, sent, lost int) {
+	o.sent, o.lost = sent, lost
+}
+
+func TestMonitorTarget_pingBurst(t *testing.T) {
+	m := newMonitor(time.Second, 100*time.Millisecond)
+	m.burst = burstSettings{count: 5, spacing: time.Millisecond}
+	o := &burstObserver{}
+	m.AddObserver(o)
+
+	mt := &monitorTarget{
+		key:     "192.0.2.1",
+		prober:  &burstFake{},
+		monitor: m,
+		history: newHistory(m.HistorySize * m.burst.count),
+		stop:    make(chan struct{}),
+	}
+	mt.pingBurst()
+
+	if o.sent != 5 || o.lost != 2 {
+		t.Errorf("expected a burst of 5 requests with 2 lost, got %d with %d lost", o.sent, o.lost)
+	}
+	if r := mt.history.compute(); r.PacketsSent != 5 || r.PacketsLost != 2 {
+		t.Errorf("expected 5 results with 2 lost in the history, got %d with %d lost", r.PacketsSent, r.PacketsLost)
+	}
+}
+
+// blockingProber answers once released
+type blockingProber struct {
+	started chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (p *blockingProber) Ping(*net.IPAddr, time.Duration, extraReplyHandler) (echoReply, error) {
+	p.once.Do(func() {
+		close(p.started)
+	})
+	<-p.release
+
+	return echoReply{rtt: time.Millisecond}, nil
+}
+
+// countObserver counts the observed results
+type countObserver struct {
+	resultObserver
+	results atomic.Int32
+}
+
+func (o *countObserver) observe(string, echoReply, error) {
+	o.results.Add(1)
+}
+
+func TestMonitor_RemoveTarget(t *testing.T) {
+	m := newMonitor(time.Millisecond, time.Second)
+	o := &countObserver{}
+	m.AddObserver(o)
+
+	p := &blockingProber{started: make(chan struct{}), release: make(chan struct{})}
+	if err := m.AddTargetDelayed("192.0.2.1", net.IPAddr{IP: net.ParseIP("192.0.2.1")}, probeOptions{prober: p}, 0); err != nil {
+		t.Fatal(err)
+	}
+	<-p.started
+
+	removed := make(chan struct{})
+	go func() {
+		m.RemoveTarget("192.0.2.1")
+		close(removed)
+	}()
+
+	select {
+	case <-removed:
+		t.Fatal("expected removal to wait for the request in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(p.release)
+	<-removed
+	if n := o.results.Load(); n != 0 {
+		t.Errorf("expected no results of the removed target, got %d", n)
+	}
+	if len(m.Export()) != 0 {
+		t.Error("expected the removed target not to be exported")
+	}
+}
+
+func TestMonitorSet_retain(t *testing.T) {
+	s := newMonitorSet()
+	kept := s.get(monitorSchedule{interval: time.Second, timeout: time.Second, historySize: 10})
+	s.get(monitorSchedule{interval: time.Minute, timeout: time.Second, historySize: 10})
+
+	s.retain(map[*monitor]bool{kept: true})
+	if len(s.monitors) != 1 || s.get(monitorSchedule{interval: time.Second, timeout: time.Second, historySize: 10}) != kept {
+		t.Errorf("expected only the monitor in use to be kept, got %d monitors", len(s.monitors))
+	}
+}
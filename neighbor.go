@@ -0,0 +1,320 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/icmp"
+	ip6 "golang.org/x/net/ipv6"
+)
+
+// ARP over Ethernet (RFC 826)
+const (
+	arpPacketLen = 28
+	arpRequest   = 1
+	arpReply     = 2
+)
+
+// neighborProber sends ARP requests (IPv4) or neighbor solicitations (IPv6)
+// on a network device and correlates the replies by the address of the
+// target. There is at most one pending request per address.
+type neighborProber struct {
+	iface    *net.Interface
+	source   net.IP // sender address of the ARP requests
+	sequence atomic.Uint32
+
+	arp *arpConn       // nil if IPv4 is not available
+	ndp net.PacketConn // nil if IPv6 is not available
+
+	pending map[string]*neighborRequest
+	mutex   sync.Mutex
+
+	wg sync.WaitGroup
+}
+
+// neighborRequest is a pending request for the link layer address of a neighbor
+type neighborRequest struct {
+	seq   uint16
+	sent  time.Time
+	reply chan echoReply
+}
+
+// newNeighborProber opens the sockets on the device of the socket options
+// and starts receiving
+func newNeighborProber(b socketOptions) (*neighborProber, error) {
+	iface, err := net.InterfaceByName(b.device)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &neighborProber{
+		iface:   iface,
+		source:  neighborSource(iface, b.sourceIP()),
+		pending: make(map[string]*neighborRequest),
+	}
+
+	// an IP version might not be available on the device
+	var arpErr, ndpErr error
+	p.arp, arpErr = listenARP(iface)
+	p.ndp, ndpErr = listenNDP(iface)
+	if p.arp == nil && p.ndp == nil {
+		return nil, fmt.Errorf("unable to open sockets on %s: %w", iface.Name, errors.Join(arpErr, ndpErr))
+	}
+
+	if p.arp != nil {
+		p.wg.Go(p.receiveARP)
+	}
+	if p.ndp != nil {
+		p.wg.Go(p.receiveNDP)
+	}
+
+	return p, nil
+}
+
+// neighborSource returns the IPv4 address the ARP requests are sent from,
+// the first address of the device if src is not an IPv4 address
+func neighborSource(iface *net.Interface, src net.IP) net.IP {
+	if src.To4() != nil {
+		return src.To4()
+	}
+
+	addrs, _ := iface.Addrs()
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4()
+		}
+	}
+
+	return net.IPv4zero.To4()
+}
+
+func listenNDP(iface *net.Interface) (net.PacketConn, error) {
+	lc := net.ListenConfig{Control: bindToDevice(iface.Name)}
+	c, err := lc.ListenPacket(context.Background(), "ip6:ipv6-icmp", "::")
+	if err != nil {
+		return nil, err
+	}
+
+	// neighbor discovery messages are only accepted with the maximum hop limit
+	pc := ip6.NewPacketConn(c)
+	var f ip6.ICMPFilter
+	f.SetAll(true)
+	f.Accept(ip6.ICMPTypeNeighborAdvertisement)
+	for _, err := range []error{pc.SetHopLimit(255), pc.SetMulticastHopLimit(255), pc.SetICMPFilter(&f)} {
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// Close closes the sockets and waits for the receivers to stop
+func (p *neighborProber) Close() error {
+	if p.arp != nil {
+		p.arp.Close()
+	}
+	if p.ndp != nil {
+		p.ndp.Close()
+	}
+	p.wg.Wait()
+
+	return nil
+}
+
+// Ping implements the prober interface. Replies to requests which already
+// have timed out are ignored.
+func (p *neighborProber) Ping(dst *net.IPAddr, timeout time.Duration, _ extraReplyHandler) (echoReply, error) {
+	req := &neighborRequest{
+		seq:   uint16(p.sequence.Add(1)),
+		reply: make(chan echoReply, 1),
+	}
+	key := dst.IP.String()
+
+	p.mutex.Lock()
+	p.pending[key] = req
+	req.sent = time.Now()
+	p.mutex.Unlock()
+
+	defer func() {
+		p.mutex.Lock()
+		if p.pending[key] == req {
+			delete(p.pending, key)
+		}
+		p.mutex.Unlock()
+	}()
+
+	if err := p.send(dst); err != nil {
+		return echoReply{}, &sendError{err}
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply := <-req.reply:
+		return reply, nil
+	case <-timer.C:
+		return echoReply{}, errTimeout
+	}
+}
+
+func (p *neighborProber) send(dst *net.IPAddr) error {
+	if ip := dst.IP.To4(); ip != nil {
+		if p.arp == nil {
+			return fmt.Errorf("no socket for address %s", dst.IP)
+		}
+
+		return p.arp.WriteBroadcast(marshalARPRequest(p.iface.HardwareAddr, p.source, ip))
+	}
+
+	if p.ndp == nil {
+		return fmt.Errorf("no socket for address %s", dst.IP)
+	}
+
+	msg := icmp.Message{
+		Type: ip6.ICMPTypeNeighborSolicitation,
+		Body: &icmp.RawBody{Data: marshalNeighborSolicitation(dst.IP, p.iface.HardwareAddr)},
+	}
+	b, err := msg.Marshal(nil) // the checksum is calculated by the kernel
+	if err != nil {
+		return err
+	}
+
+	_, err = p.ndp.WriteTo(b, &net.IPAddr{IP: solicitedNodeAddr(dst.IP), Zone: p.iface.Name})
+	return err
+}
+
+// handleReply passes the link layer address of a neighbor to the pending request
+func (p *neighborProber) handleReply(ip net.IP, mac net.HardwareAddr, received time.Time) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	key := ip.String()
+	req, found := p.pending[key]
+	if !found {
+		return
+	}
+
+	delete(p.pending, key)
+	req.reply <- echoReply{seq: req.seq, rtt: received.Sub(req.sent), mac: mac}
+}
+
+// receiveARP reads ARP replies until the socket gets closed
+func (p *neighborProber) receiveARP() {
+	b := make([]byte, 1500)
+
+	for {
+		n, err := p.arp.Read(b)
+		if err != nil {
+			return // socket gone
+		}
+
+		if ip, mac, ok := parseARPReply(b[:n]); ok {
+			p.handleReply(ip, mac, time.Now())
+		}
+	}
+}
+
+// receiveNDP reads neighbor advertisements until the socket gets closed
+func (p *neighborProber) receiveNDP() {
+	b := make([]byte, 1500)
+
+	for {
+		n, _, err := p.ndp.ReadFrom(b)
+		if err != nil {
+			return // socket gone
+		}
+
+		msg, err := icmp.ParseMessage(protocolICMPv6, b[:n])
+		if err != nil || msg.Type != ip6.ICMPTypeNeighborAdvertisement {
+			continue
+		}
+
+		body, ok := msg.Body.(*icmp.RawBody)
+		if !ok {
+			continue
+		}
+
+		if ip, mac, ok := parseNeighborAdvertisement(body.Data); ok {
+			p.handleReply(ip, mac, time.Now())
+		}
+	}
+}
+
+// marshalARPRequest returns an ARP request for the hardware address of target
+func marshalARPRequest(mac net.HardwareAddr, source, target net.IP) []byte {
+	b := make([]byte, arpPacketLen)
+	binary.BigEndian.PutUint16(b[0:], 1)      // Ethernet
+	binary.BigEndian.PutUint16(b[2:], 0x0800) // IPv4
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:], arpRequest)
+	copy(b[8:14], mac)
+	copy(b[14:18], source.To4())
+	copy(b[24:28], target.To4())
+
+	return b
+}
+
+// parseARPReply returns the sender addresses of an ARP reply
+func parseARPReply(b []byte) (net.IP, net.HardwareAddr, bool) {
+	if len(b) < arpPacketLen || b[4] != 6 || b[5] != 4 || binary.BigEndian.Uint16(b[6:]) != arpReply {
+		return nil, nil, false
+	}
+
+	return slices.Clone(net.IP(b[14:18])), slices.Clone(net.HardwareAddr(b[8:14])), true
+}
+
+// marshalNeighborSolicitation returns the body of a neighbor solicitation
+// for target with the source link layer address option (RFC 4861, section 4.3)
+func marshalNeighborSolicitation(target net.IP, mac net.HardwareAddr) []byte {
+	b := make([]byte, 20, 20+8)
+	copy(b[4:], target.To16())
+
+	if len(mac) == 6 {
+		b = append(b, 1, 1) // source link layer address, 8 bytes
+		b = append(b, mac...)
+	}
+
+	return b
+}
+
+// parseNeighborAdvertisement returns the target address and the target link
+// layer address option (nil if missing) of a neighbor advertisement body
+func parseNeighborAdvertisement(b []byte) (net.IP, net.HardwareAddr, bool) {
+	if len(b) < 20 {
+		return nil, nil, false
+	}
+
+	ip := slices.Clone(net.IP(b[4:20]))
+	for opts := b[20:]; len(opts) >= 8; {
+		l := int(opts[1]) * 8
+		if l == 0 || l > len(opts) {
+			break
+		}
+		if opts[0] == 2 { // target link layer address
+			return ip, slices.Clone(net.HardwareAddr(opts[2:l])), true
+		}
+		opts = opts[l:]
+	}
+
+	return ip, nil, true
+}
+
+// solicitedNodeAddr returns the solicited-node multicast address of ip
+func solicitedNodeAddr(ip net.IP) net.IP {
+	addr := net.ParseIP("ff02::1:ff00:0")
+	copy(addr[13:], ip.To16()[13:])
+
+	return addr
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// arpConn is a packet socket sending and receiving ARP packets on a device
+type arpConn struct {
+	f       *os.File
+	ifindex int
+}
+
+// listenARP opens a packet socket receiving the ARP packets of the device
+func listenARP(iface *net.Interface) (*arpConn, error) {
+	proto := htons(unix.ETH_P_ARP)
+	s, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC|unix.SOCK_NONBLOCK, int(proto))
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := unix.Bind(s, &unix.SockaddrLinklayer{Protocol: proto, Ifindex: iface.Index}); err != nil {
+		unix.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	return &arpConn{f: os.NewFile(uintptr(s), "arp"), ifindex: iface.Index}, nil
+}
+
+// Read reads the next ARP packet
+func (c *arpConn) Read(b []byte) (int, error) {
+	raw, err := c.f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var n int
+	var readErr error
+	err = raw.Read(func(fd uintptr) bool {
+		n, _, readErr = unix.Recvfrom(int(fd), b, 0)
+		return readErr != unix.EAGAIN
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return n, os.NewSyscallError("recvfrom", readErr)
+}
+
+// WriteBroadcast sends an ARP packet to the broadcast address
+func (c *arpConn) WriteBroadcast(b []byte) error {
+	raw, err := c.f.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	to := &unix.SockaddrLinklayer{
+		Protocol: htons(unix.ETH_P_ARP),
+		Ifindex:  c.ifindex,
+		Halen:    6,
+		Addr:     [8]byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+	}
+
+	var writeErr error
+	err = raw.Write(func(fd uintptr) bool {
+		writeErr = unix.Sendto(int(fd), b, 0, to)
+		return writeErr != unix.EAGAIN
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("sendto", writeErr)
+}
+
+// Close closes the socket, pending reads return an error
+func (c *arpConn) Close() error {
+	return c.f.Close()
+}
+
+// htons converts a short to network byte order
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+
+	return binary.NativeEndian.Uint16(b)
+}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+)
+
+// arpConn is only supported on Linux
+type arpConn struct{}
+
+// listenARP is only supported on Linux
+func listenARP(*net.Interface) (*arpConn, error) {
+	return nil, errors.New("ARP probes are not supported on this platform")
+}
+
+func (c *arpConn) Read([]byte) (int, error) {
+	return 0, net.ErrClosed
+}
+
+func (c *arpConn) WriteBroadcast([]byte) error {
+	return net.ErrClosed
+}
+
+func (c *arpConn) Close() error {
+	return nil
+}
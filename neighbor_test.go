@@ -0,0 +1,43 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"testing"
+)
+
+func TestParseARPReply(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	b := marshalARPRequest(mac, net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"))
+
+	if _, _, ok := parseARPReply(b); ok {
+		t.Fatal("expected request not to be parsed as reply")
+	}
+
+	b[7] = arpReply
+	ip, hw, ok := parseARPReply(b)
+	if !ok || !ip.Equal(net.ParseIP("192.0.2.1")) || hw.String() != mac.String() {
+		t.Errorf("got %v %v (%v)", ip, hw, ok)
+	}
+}
+
+func TestParseNeighborAdvertisement(t *testing.T) {
+	mac, _ := net.ParseMAC("02:00:00:00:00:01")
+	target := net.ParseIP("2001:db8::1")
+
+	// same layout as a solicitation, with the target instead of the source link layer address
+	b := marshalNeighborSolicitation(target, mac)
+	b[20] = 2
+
+	ip, hw, ok := parseNeighborAdvertisement(b)
+	if !ok || !ip.Equal(target) || hw.String() != mac.String() {
+		t.Errorf("got %v %v (%v)", ip, hw, ok)
+	}
+}
+
+func TestSolicitedNodeAddr(t *testing.T) {
+	if got := solicitedNodeAddr(net.ParseIP("2001:db8::12:3456")); got.String() != "ff02::1:ff12:3456" {
+		t.Errorf("got %s", got)
+	}
+}
@@ -0,0 +1,58 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// payloadState is the result of comparing the payload of a reply with the
+// payload of the request
+type payloadState int
+
+const (
+	payloadIntact    payloadState = iota // or not verified
+	payloadCorrupted                     // differs from the payload sent
+	payloadTruncated                     // shorter than the payload sent
+)
+
+// dslPattern are the flag and escape bytes of HDLC framing (used by PPP over
+// DSL), which are escaped on the line and double the size of the frame
+var dslPattern = [2]byte{0x7e, 0x7d}
+
+// fillPayload fills b with the pattern, random bytes if unknown
+func fillPayload(b []byte, pattern string) {
+	switch pattern {
+	case config.PayloadZeros:
+		clear(b)
+	case config.PayloadOnes:
+		for i := range b {
+			b[i] = 0xff
+		}
+	case config.PayloadDSL:
+		for i := range b {
+			b[i] = dslPattern[i%2]
+		}
+	default:
+		rand.Read(b)
+	}
+}
+
+// verifyPayload compares the payload of a reply with the payload sent, nil
+// if not to be verified. Trailing bytes of the reply (e.g. padding) are
+// ignored.
+func verifyPayload(sent, received []byte) payloadState {
+	switch {
+	case sent == nil:
+		return payloadIntact
+	case len(received) < len(sent):
+		return payloadTruncated
+	case !bytes.Equal(sent, received[:len(sent)]):
+		return payloadCorrupted
+	default:
+		return payloadIntact
+	}
+}
@@ -0,0 +1,48 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestFillPayload(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		want    []byte
+	}{
+		{config.PayloadZeros, []byte{0, 0, 0, 0, 0}},
+		{config.PayloadOnes, []byte{0xff, 0xff, 0xff, 0xff, 0xff}},
+		{config.PayloadDSL, []byte{0x7e, 0x7d, 0x7e, 0x7d, 0x7e}},
+	} {
+		b := []byte{1, 2, 3, 4, 5}
+		fillPayload(b, tt.pattern)
+		if !bytes.Equal(b, tt.want) {
+			t.Errorf("pattern %s: got %x, want %x", tt.pattern, b, tt.want)
+		}
+	}
+}
+
+func TestVerifyPayload(t *testing.T) {
+	sent := []byte{0x7e, 0x7d, 0x7e, 0x7d}
+
+	for _, tt := range []struct {
+		name     string
+		sent     []byte
+		received []byte
+		want     payloadState
+	}{
+		{"intact", sent, []byte{0x7e, 0x7d, 0x7e, 0x7d}, payloadIntact},
+		{"padded", sent, []byte{0x7e, 0x7d, 0x7e, 0x7d, 0}, payloadIntact},
+		{"corrupted", sent, []byte{0x7e, 0x7d, 0x7d, 0x7d}, payloadCorrupted},
+		{"truncated", sent, []byte{0x7e, 0x7d}, payloadTruncated},
+		{"not verified", nil, []byte{1}, payloadIntact},
+	} {
+		if got := verifyPayload(tt.sent, tt.received); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
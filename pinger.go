@@ -0,0 +1,620 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/icmp"
+
+	"github.com/czerwonk/ping_exporter/config"
+	ip4 "golang.org/x/net/ipv4"
+	ip6 "golang.org/x/net/ipv6"
+)
+
+const (
+	protocolICMP   = 1
+	protocolICMPv6 = 58
+)
+
+var (
+	errPingerClosed = errors.New("pinger closed")
+	errNotBound     = errors.New("need at least one bind address")
+	errTimeout      = errors.New("i/o timeout")
+	errTooBig       = errors.New("packet too big") // fragmentation needed but DF set
+	errTTLExceeded  = errors.New("time exceeded")  // TTL or hop limit exceeded in transit
+)
+
+// pingerInstances is used to give each pinger of the process its own echo identifier
+var pingerInstances atomic.Uint32
+
+// pinger sends ICMP echo requests and correlates the replies
+type pinger struct {
+	id       uint16
+	datagram bool // unprivileged ICMP sockets, the kernel sets the echo identifier
+
+	payload      []byte
+	pattern      string // see config.PayloadRandom
+	payloadMutex sync.RWMutex
+
+	conn4  net.PacketConn
+	conn6  net.PacketConn
+	write4 sync.Mutex
+	write6 sync.Mutex
+
+	requests *requestTable
+
+	// extended echo requests have 8 bit sequence numbers of their own
+	interfaceRequests *requestTable
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// newPinger opens the ICMP sockets on the given addresses (empty to disable
+// the IP version) and starts receiving. Unprivileged mode uses datagram
+// sockets instead of raw sockets.
+func newPinger(bind4, bind6 string, unprivileged bool) (*pinger, error) {
+	conn4, err := listenICMP(bind4, false, unprivileged)
+	if err != nil {
+		return nil, err
+	}
+
+	conn6, err := listenICMP(bind6, true, unprivileged)
+	if err != nil {
+		if conn4 != nil {
+			conn4.Close()
+		}
+		return nil, err
+	}
+
+	if conn4 == nil && conn6 == nil {
+		return nil, errNotBound
+	}
+
+	p := &pinger{
+		id:       uint16(os.Getpid()) + uint16(pingerInstances.Add(1)),
+		datagram: unprivileged,
+		conn4:    conn4,
+		conn6:    conn6,
+		requests: newRequestTable(),
+		stop:     make(chan struct{}),
+
+		interfaceRequests: newRequestTableOfSize(1 << 8),
+	}
+	p.SetPayload(56, config.PayloadRandom)
+
+	if conn4 != nil {
+		read := newTTLReader(conn4, false)
+		p.wg.Go(func() {
+			p.receive(protocolICMP, conn4, read)
+		})
+	}
+	if conn6 != nil {
+		read := newTTLReader(conn6, true)
+		p.wg.Go(func() {
+			p.receive(protocolICMPv6, conn6, read)
+		})
+	}
+	p.wg.Go(func() {
+		p.requests.expire(p.stop)
+	})
+	p.wg.Go(func() {
+		p.interfaceRequests.expire(p.stop)
+	})
+
+	return p, nil
+}
+
+func listenICMP(address string, v6, datagram bool) (net.PacketConn, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	if datagram {
+		c, err := listenICMPDatagram(address, v6)
+		if err != nil {
+			return nil, err
+		}
+
+		// datagram sockets only report ICMP error messages by their error queue
+		if err := enableRecvErr(c, v6); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("failed to enable receiving ICMP errors: %w", err)
+		}
+		return c, nil
+	}
+
+	network := "ip4:icmp"
+	if v6 {
+		network = "ip6:ipv6-icmp"
+	}
+	c, err := net.ListenPacket(network, address)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("%w (raw sockets require CAP_NET_RAW, see --ping.unprivileged)", err)
+	}
+
+	return c, err
+}
+
+// Close closes the sockets and waits for the receivers to stop
+func (p *pinger) Close() {
+	close(p.stop)
+	for _, c := range []net.PacketConn{p.conn4, p.conn6} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	p.wg.Wait()
+}
+
+// SetPayload sets a payload of the given size filled with the pattern
+func (p *pinger) SetPayload(size uint16, pattern string) {
+	payload := make([]byte, size)
+	fillPayload(payload, pattern)
+
+	p.payloadMutex.Lock()
+	defer p.payloadMutex.Unlock()
+	p.payload = payload
+	p.pattern = pattern
+}
+
+// SetTrafficClass sets the TOS (IPv4) and traffic class (IPv6) of the requests
+func (p *pinger) SetTrafficClass(tos int) error {
+	return setTrafficClass(p.conn4, p.conn6, tos)
+}
+
+// setTrafficClass sets the TOS of an IPv4 socket and the traffic class of an
+// IPv6 socket, nil sockets are skipped
+func setTrafficClass(conn4, conn6 net.PacketConn, tos int) error {
+	if conn4 != nil {
+		if err := ip4.NewPacketConn(conn4).SetTOS(tos); err != nil {
+			return err
+		}
+	}
+	if conn6 != nil {
+		if err := ip6.NewPacketConn(conn6).SetTrafficClass(tos); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PayloadSize returns the current payload size
+func (p *pinger) PayloadSize() uint16 {
+	p.payloadMutex.RLock()
+	defer p.payloadMutex.RUnlock()
+	return uint16(len(p.payload))
+}
+
+// Ping sends an echo request to dst and waits for the reply until the
+// timeout expires. Replies received later on are passed to h.
+func (p *pinger) Ping(dst *net.IPAddr, timeout time.Duration, h extraReplyHandler) (echoReply, error) {
+	p.payloadMutex.RLock()
+	payload := p.payload
+	p.payloadMutex.RUnlock()
+
+	req, err := p.send(dst, payload, 0, h)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// PingTTL sends an echo request with the given TTL (IPv4) or hop limit (IPv6)
+// to dst and waits for the reply of the destination or the time exceeded
+// message of a router until the timeout expires
+func (p *pinger) PingTTL(dst *net.IPAddr, ttl int, timeout time.Duration) (echoReply, error) {
+	p.payloadMutex.RLock()
+	payload := p.payload
+	p.payloadMutex.RUnlock()
+
+	req, err := p.send(dst, payload, ttl, nil)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// PingSize sends an echo request with a payload of the given size filled with
+// the pattern of the pinger to dst and waits for the reply until the timeout
+// expires
+func (p *pinger) PingSize(dst *net.IPAddr, size uint16, timeout time.Duration) (echoReply, error) {
+	p.payloadMutex.RLock()
+	pattern := p.pattern
+	p.payloadMutex.RUnlock()
+
+	payload := make([]byte, size)
+	fillPayload(payload, pattern)
+
+	req, err := p.send(dst, payload, 0, nil)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// PingFlow sends an echo request to dst like Ping, with the payload adjusted
+// to keep the checksum the same for all requests of the flow
+func (p *pinger) PingFlow(dst *net.IPAddr, flow int, timeout time.Duration, h extraReplyHandler) (echoReply, error) {
+	p.payloadMutex.RLock()
+	payload := slices.Clone(p.payload)
+	p.payloadMutex.RUnlock()
+
+	req, err := p.sendMessage(dst, p.requests, func(seq uint16) icmp.Message {
+		// datagram sockets replace the identifier, which shifts the checksums of
+		// all flows by the same amount
+		if len(payload) >= 2 {
+			balanceFlowChecksum(dst.IP.To4() == nil, p.id, seq, payload, flow)
+		}
+		return p.echoMessage(dst, seq, payload)
+	}, payload, 0, h)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// send sends an echo request with the given payload, ttl is the TTL (IPv4) or
+// hop limit (IPv6) of the request, 0 for the default of the socket
+func (p *pinger) send(dst *net.IPAddr, payload []byte, ttl int, h extraReplyHandler) (*echoRequest, error) {
+	return p.sendMessage(dst, p.requests, func(seq uint16) icmp.Message {
+		return p.echoMessage(dst, seq, payload)
+	}, payload, ttl, h)
+}
+
+// echoMessage returns the echo request to dst with the given sequence number and payload
+func (p *pinger) echoMessage(dst *net.IPAddr, seq uint16, payload []byte) icmp.Message {
+	msg := icmp.Message{
+		Type: ip4.ICMPTypeEcho,
+		Body: &icmp.Echo{
+			ID:   int(p.id),
+			Seq:  int(seq),
+			Data: payload,
+		},
+	}
+	if dst.IP.To4() == nil {
+		msg.Type = ip6.ICMPTypeEchoRequest
+	}
+
+	return msg
+}
+
+// PingTimestamp sends an ICMP timestamp request to dst (IPv4 only) and
+// estimates the one way delays from the reply
+func (p *pinger) PingTimestamp(dst *net.IPAddr, timeout time.Duration) (oneWayDelays, error) {
+	if dst.IP.To4() == nil {
+		return oneWayDelays{}, fmt.Errorf("timestamp requests are not supported by IPv6")
+	}
+
+	req, err := p.sendMessage(dst, p.requests, func(seq uint16) icmp.Message {
+		originate := uint32(timeOfDay(time.Now()) / time.Millisecond)
+		return icmp.Message{
+			Type: ip4.ICMPTypeTimestamp,
+			Body: &icmp.RawBody{Data: marshalTimestamps(p.id, seq, icmpTimestamps{originate})},
+		}
+	}, nil, 0, nil)
+	if err != nil {
+		return oneWayDelays{}, err
+	}
+
+	reply, err := p.requests.wait(req, timeout)
+	if err != nil {
+		return oneWayDelays{}, err
+	}
+
+	return computeOneWayDelays(req.sent, req.sent.Add(reply.rtt), reply.timestamps)
+}
+
+// PingInterface sends an ICMP extended echo request querying the state of an
+// interface of dst and waits for the reply until the timeout expires. The
+// sequence number of the reply is not set, since the 8 bits wrap around too
+// fast to detect reordering.
+func (p *pinger) PingInterface(dst *net.IPAddr, query *icmp.InterfaceIdent, timeout time.Duration) (echoReply, error) {
+	req, err := p.sendMessage(dst, p.interfaceRequests, func(seq uint16) icmp.Message {
+		msg := icmp.Message{
+			Type: ip4.ICMPTypeExtendedEchoRequest,
+			Body: &icmp.ExtendedEchoRequest{
+				ID:         int(p.id),
+				Seq:        int(seq),
+				Local:      true, // the interface belongs to dst, required for names and indexes
+				Extensions: []icmp.Extension{query},
+			},
+		}
+		if dst.IP.To4() == nil {
+			msg.Type = ip6.ICMPTypeExtendedEchoRequest
+		}
+		return msg
+	}, nil, 0, nil)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	reply, err := p.interfaceRequests.wait(req, timeout)
+	reply.seq = 0
+	return reply, err
+}
+
+// sendMessage adds a request to the request table and sends the ICMP message
+// returned by msg for the sequence number assigned, see send
+func (p *pinger) sendMessage(dst *net.IPAddr, requests *requestTable, msg func(seq uint16) icmp.Message, payload []byte, ttl int, h extraReplyHandler) (*echoRequest, error) {
+	conn, lock, proto := p.conn4, &p.write4, protocolICMP
+	if dst.IP.To4() == nil {
+		conn, lock, proto = p.conn6, &p.write6, protocolICMPv6
+	}
+
+	if conn == nil {
+		return nil, fmt.Errorf("no socket for address %s", dst.IP)
+	}
+
+	req, err := requests.add(dst.IP, payload, ttl, h)
+	if err != nil {
+		return nil, &sendError{err}
+	}
+
+	m := msg(req.seq)
+	b, err := m.Marshal(nil)
+	if err != nil {
+		requests.remove(req.seq)
+		return nil, err
+	}
+
+	var addr net.Addr = dst
+	if p.datagram {
+		addr = &net.UDPAddr{IP: dst.IP, Zone: dst.Zone}
+	}
+
+	lock.Lock()
+	requests.markSent(req)
+	err = writeWithTTL(conn, b, addr, ttl)
+	if err != nil && p.datagram && readErrQueue(conn, p.queuedErrorHandler(proto)) == nil {
+		// the write reported a received ICMP error message instead
+		err = writeWithTTL(conn, b, addr, ttl)
+	}
+	lock.Unlock()
+
+	if err != nil {
+		requests.remove(req.seq)
+		if errors.Is(err, syscall.EMSGSIZE) {
+			// larger than the MTU of the interface
+			err = errTooBig
+		}
+		return nil, &sendError{err}
+	}
+
+	return req, nil
+}
+
+// writeWithTTL writes b to addr with the given TTL (IPv4) or hop limit (IPv6),
+// 0 for the default of the socket. The socket option is reset after writing,
+// so the caller has to hold the write lock of the socket.
+func writeWithTTL(c net.PacketConn, b []byte, addr net.Addr, ttl int) error {
+	if ttl == 0 {
+		_, err := c.WriteTo(b, addr)
+		return err
+	}
+
+	var get func() (int, error)
+	var set func(int) error
+	if isIPv6Addr(addr) {
+		pc := ip6.NewPacketConn(c)
+		get, set = pc.HopLimit, pc.SetHopLimit
+	} else {
+		pc := ip4.NewPacketConn(c)
+		get, set = pc.TTL, pc.SetTTL
+	}
+
+	prev, err := get()
+	if err != nil {
+		return err
+	}
+	if err := set(ttl); err != nil {
+		return err
+	}
+	defer set(prev)
+
+	_, err = c.WriteTo(b, addr)
+	return err
+}
+
+// isIPv6Addr checks if addr is an IPv6 address
+func isIPv6Addr(addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.IPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	}
+
+	return ip.To4() == nil
+}
+
+// readFunc reads a message and returns its length, the TTL (or hop limit)
+// and the source address
+type readFunc func(b []byte) (int, int, net.Addr, error)
+
+// newTTLReader returns a readFunc for c, which reports the TTL (IPv4) or the
+// hop limit (IPv6) if supported by the platform
+func newTTLReader(c net.PacketConn, v6 bool) readFunc {
+	if v6 {
+		pc := ip6.NewPacketConn(c)
+		if err := pc.SetControlMessage(ip6.FlagHopLimit, true); err != nil {
+			log.Warnf("unable to receive hop limit of IPv6 packets: %v", err)
+		}
+		return func(b []byte) (int, int, net.Addr, error) {
+			n, cm, src, err := pc.ReadFrom(b)
+			if cm == nil {
+				return n, 0, src, err
+			}
+			return n, cm.HopLimit, src, err
+		}
+	}
+
+	pc := ip4.NewPacketConn(c)
+	if err := pc.SetControlMessage(ip4.FlagTTL, true); err != nil {
+		log.Warnf("unable to receive TTL of IPv4 packets: %v", err)
+	}
+	return func(b []byte) (int, int, net.Addr, error) {
+		n, cm, src, err := pc.ReadFrom(b)
+		if cm == nil {
+			return n, 0, src, err
+		}
+		return n, cm.TTL, src, err
+	}
+}
+
+// receive reads from the socket until it gets closed
+func (p *pinger) receive(proto int, conn net.PacketConn, read readFunc) {
+	b := make([]byte, 65536)
+	handleQueuedError := p.queuedErrorHandler(proto)
+
+	for {
+		n, ttl, addr, err := read(b)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			// a datagram socket reports a received ICMP error message by the next read
+			if !p.datagram || errors.Is(err, net.ErrClosed) || readErrQueue(conn, handleQueuedError) != nil {
+				break // socket gone
+			}
+			continue
+		}
+
+		var src net.IP
+		switch a := addr.(type) {
+		case *net.IPAddr:
+			src = a.IP
+		case *net.UDPAddr:
+			src = a.IP
+		default:
+			continue
+		}
+		p.handleMessage(proto, b[:n], src, ttl, time.Now())
+	}
+
+	p.requests.failAll(errPingerClosed)
+	p.interfaceRequests.failAll(errPingerClosed)
+}
+
+func (p *pinger) handleMessage(proto int, b []byte, src net.IP, ttl int, received time.Time) {
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return
+	}
+
+	switch msg.Type {
+	case ip4.ICMPTypeEchoReply, ip6.ICMPTypeEchoReply:
+		echo, ok := msg.Body.(*icmp.Echo)
+		if !ok || !p.isOwnID(echo.ID) {
+			return
+		}
+		p.requests.handleReply(uint16(echo.Seq), src, ttl, echo.Data, received)
+
+	case ip4.ICMPTypeTimestampReply:
+		body, ok := msg.Body.(*icmp.RawBody)
+		if !ok {
+			return
+		}
+
+		id, seq, ts, ok := parseTimestamps(body.Data)
+		if !ok || !p.isOwnID(int(id)) {
+			return
+		}
+		p.requests.handleQueryReply(seq, src, echoReply{timestamps: ts}, received)
+
+	case ip4.ICMPTypeExtendedEchoReply, ip6.ICMPTypeExtendedEchoReply:
+		body, ok := msg.Body.(*icmp.ExtendedEchoReply)
+		if !ok || !p.isOwnID(body.ID) {
+			return
+		}
+
+		state := &interfaceState{code: msg.Code, active: body.Active, ipv4: body.IPv4, ipv6: body.IPv6}
+		p.interfaceRequests.handleQueryReply(uint16(body.Seq), src, echoReply{ttl: ttl, iface: state}, received)
+
+	case ip4.ICMPTypeDestinationUnreachable, ip6.ICMPTypeDestinationUnreachable,
+		ip4.ICMPTypeTimeExceeded, ip6.ICMPTypeTimeExceeded,
+		ip4.ICMPTypeParameterProblem, ip6.ICMPTypeParameterProblem,
+		ip6.ICMPTypePacketTooBig:
+		echo := parseEmbeddedEcho(proto, errorMessageData(msg.Body))
+		if echo == nil || !p.isOwnID(echo.ID) {
+			return
+		}
+
+		p.handleICMPError(uint16(echo.Seq), &icmpError{typ: msg.Type, code: msg.Code, reporter: src}, received)
+	}
+}
+
+// handleICMPError passes an ICMP error message referring to an echo request to the request
+func (p *pinger) handleICMPError(seq uint16, e *icmpError, received time.Time) {
+	if errors.Is(e, errTTLExceeded) {
+		p.requests.handleTimeExceeded(seq, e, received)
+		return
+	}
+
+	p.requests.fail(seq, e)
+}
+
+// queuedErrorHandler returns the handler of the ICMP error messages read from
+// the error queue of a datagram socket, which start with the echo request they
+// refer to
+func (p *pinger) queuedErrorHandler(proto int) func(payload []byte, e *icmpError) {
+	return func(payload []byte, e *icmpError) {
+		msg, err := icmp.ParseMessage(proto, payload)
+		if err != nil {
+			return
+		}
+
+		if echo, ok := msg.Body.(*icmp.Echo); ok {
+			p.handleICMPError(uint16(echo.Seq), e, time.Now())
+		}
+	}
+}
+
+// isOwnID checks if an echo identifier belongs to the requests of the pinger.
+// Datagram sockets only receive replies to their own requests.
+func (p *pinger) isOwnID(id int) bool {
+	return p.datagram || uint16(id) == p.id
+}
+
+// parseEmbeddedEcho returns the echo request contained in an ICMP error message
+func parseEmbeddedEcho(proto int, b []byte) *icmp.Echo {
+	switch proto {
+	case protocolICMP:
+		hdr, err := ip4.ParseHeader(b)
+		if err != nil {
+			return nil
+		}
+		b = b[hdr.Len:]
+	case protocolICMPv6:
+		if len(b) < ip6.HeaderLen {
+			return nil
+		}
+		b = b[ip6.HeaderLen:]
+	}
+
+	msg, err := icmp.ParseMessage(proto, b)
+	if err != nil {
+		return nil
+	}
+
+	echo, _ := msg.Body.(*icmp.Echo)
+	return echo
+}
@@ -0,0 +1,309 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+const pingGroupRangeFile = "/proc/sys/net/ipv4/ping_group_range"
+
+// origins of the errors in the error queue of a socket (linux/errqueue.h)
+const (
+	soEEOriginICMP  = 2
+	soEEOriginICMP6 = 3
+)
+
+// SetMark sets the SO_MARK socket option on the sockets of the pinger
+func (p *pinger) SetMark(mark uint) error {
+	for _, c := range []net.PacketConn{p.conn4, p.conn6} {
+		if c == nil {
+			continue
+		}
+
+		if err := setSockoptInt(c, syscall.SOL_SOCKET, syscall.SO_MARK, int(mark)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SetDontFragment sets the DF flag on the requests and disables the
+// fragmentation by the kernel, requests larger than the MTU of the interface
+// fail instead. The path MTU learned by the kernel is ignored, so the requests
+// are sent regardless of earlier ICMP fragmentation needed messages.
+func (p *pinger) SetDontFragment() error {
+	if p.conn4 != nil {
+		if err := setSockoptInt(p.conn4, syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_PROBE); err != nil {
+			return err
+		}
+	}
+
+	if p.conn6 != nil {
+		if err := setSockoptInt(p.conn6, syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_PROBE); err != nil {
+			return err
+		}
+		if err := setSockoptInt(p.conn6, syscall.IPPROTO_IPV6, unix.IPV6_DONTFRAG, 1); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// BindToDevice binds the sockets of the pinger to a network device or VRF (SO_BINDTODEVICE)
+func (p *pinger) BindToDevice(device string) error {
+	for _, c := range []net.PacketConn{p.conn4, p.conn6} {
+		if c == nil {
+			continue
+		}
+
+		raw, err := rawConn(c)
+		if err != nil {
+			return err
+		}
+
+		if err := bindToDevice(device)("", "", raw); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// bindToDevice returns a control function for dialers and listeners binding
+// the socket to a network device or VRF, nil if device is empty
+func bindToDevice(device string) controlFunc {
+	if device == "" {
+		return nil
+	}
+
+	return func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, device)
+		})
+		if err != nil {
+			return err
+		}
+
+		return os.NewSyscallError("setsockopt", sockErr)
+	}
+}
+
+// markControl returns a control function for dialers and listeners setting
+// SO_MARK, nil if mark is 0
+func markControl(mark uint) controlFunc {
+	if mark == 0 {
+		return nil
+	}
+
+	return func(_, _ string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+		})
+		if err != nil {
+			return err
+		}
+
+		return os.NewSyscallError("setsockopt", sockErr)
+	}
+}
+
+// trafficClassControl returns a control function for dialers setting the TOS
+// (IPv4) or the traffic class (IPv6), nil if tos is 0
+func trafficClassControl(tos int) controlFunc {
+	if tos == 0 {
+		return nil
+	}
+
+	return func(network, _ string, c syscall.RawConn) error {
+		level, opt := syscall.IPPROTO_IP, syscall.IP_TOS
+		if strings.HasSuffix(network, "6") {
+			level, opt = syscall.IPPROTO_IPV6, syscall.IPV6_TCLASS
+		}
+
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), level, opt, tos)
+		})
+		if err != nil {
+			return err
+		}
+
+		return os.NewSyscallError("setsockopt", sockErr)
+	}
+}
+
+// enableRecvErr enables the reception of ICMP error messages referring to the
+// datagrams sent by a UDP or ICMP datagram socket (IP_RECVERR), see readErrQueue
+func enableRecvErr(c net.PacketConn, v6 bool) error {
+	if v6 {
+		return setSockoptInt(c, syscall.IPPROTO_IPV6, syscall.IPV6_RECVERR, 1)
+	}
+
+	return setSockoptInt(c, syscall.IPPROTO_IP, syscall.IP_RECVERR, 1)
+}
+
+// readErrQueue reads the ICMP error messages queued for a datagram socket without
+// blocking and passes them with the start of the payload of the datagram they
+// refer to to fn. Since the socket reports the error of the latest message to
+// the next read or write, it has to be called after any failed read or write.
+func readErrQueue(c net.PacketConn, fn func(payload []byte, e *icmpError)) error {
+	raw, err := rawConn(c)
+	if err != nil {
+		return err
+	}
+
+	b := make([]byte, 64)
+	oob := make([]byte, 512)
+	var readErr error
+	err = raw.Control(func(fd uintptr) {
+		for {
+			n, oobn, _, _, err := syscall.Recvmsg(int(fd), b, oob, syscall.MSG_ERRQUEUE|syscall.MSG_DONTWAIT)
+			if errors.Is(err, syscall.EAGAIN) {
+				return
+			}
+			if err != nil {
+				readErr = os.NewSyscallError("recvmsg", err)
+				return
+			}
+
+			if e := parseExtendedErr(oob[:oobn]); e != nil {
+				fn(b[:n], e)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	return readErr
+}
+
+// parseExtendedErr returns the ICMP error message in the control messages of
+// the error queue (struct sock_extended_err), nil if there is none
+func parseExtendedErr(oob []byte) *icmpError {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, m := range msgs {
+		v4 := m.Header.Level == syscall.SOL_IP && m.Header.Type == syscall.IP_RECVERR
+		v6 := m.Header.Level == syscall.SOL_IPV6 && m.Header.Type == syscall.IPV6_RECVERR
+		if !v4 && !v6 || len(m.Data) < 16 {
+			continue
+		}
+
+		origin, typ, code := m.Data[4], m.Data[5], m.Data[6]
+		if origin != soEEOriginICMP && origin != soEEOriginICMP6 {
+			continue
+		}
+
+		// the sender follows as struct sockaddr_in or sockaddr_in6
+		var from net.IP
+		sa := m.Data[16:]
+		switch {
+		case len(sa) >= 8 && binary.NativeEndian.Uint16(sa) == syscall.AF_INET:
+			from = net.IP(slices.Clone(sa[4:8]))
+		case len(sa) >= 24 && binary.NativeEndian.Uint16(sa) == syscall.AF_INET6:
+			from = net.IP(slices.Clone(sa[8:24]))
+		}
+
+		return &icmpError{typ: icmpErrorType(origin == soEEOriginICMP6, int(typ)), code: int(code), reporter: from}
+	}
+
+	return nil
+}
+
+func setSockoptInt(c net.PacketConn, level, opt, value int) error {
+	raw, err := rawConn(c)
+	if err != nil {
+		return err
+	}
+
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), level, opt, value)
+	})
+	if err != nil {
+		return err
+	}
+
+	return os.NewSyscallError("setsockopt", sockErr)
+}
+
+func rawConn(c net.PacketConn) (syscall.RawConn, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return nil, errors.New("invalid connection type")
+	}
+
+	return sc.SyscallConn()
+}
+
+// listenICMPDatagram opens an unprivileged ICMP socket (SOCK_DGRAM), which is
+// permitted for the groups in net.ipv4.ping_group_range
+func listenICMPDatagram(address string, v6 bool) (net.PacketConn, error) {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid bind address %q", address)
+	}
+
+	family, proto := syscall.AF_INET, protocolICMP
+	var sa syscall.Sockaddr
+	if v6 {
+		family, proto = syscall.AF_INET6, protocolICMPv6
+		sa6 := &syscall.SockaddrInet6{}
+		copy(sa6.Addr[:], ip.To16())
+		sa = sa6
+	} else {
+		sa4 := &syscall.SockaddrInet4{}
+		copy(sa4.Addr[:], ip.To4())
+		sa = sa4
+	}
+
+	s, err := syscall.Socket(family, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, unprivilegedICMPError(err)
+	}
+
+	if err := syscall.Bind(s, sa); err != nil {
+		syscall.Close(s)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	f := os.NewFile(uintptr(s), "icmp")
+	defer f.Close()
+
+	return net.FilePacketConn(f)
+}
+
+// unprivilegedICMPError explains why an unprivileged ICMP socket could not be opened
+func unprivilegedICMPError(err error) error {
+	switch {
+	case errors.Is(err, syscall.EACCES):
+		groups := "unknown"
+		if b, rerr := os.ReadFile(pingGroupRangeFile); rerr == nil {
+			groups = strings.Join(strings.Fields(string(b)), " ")
+		}
+		return fmt.Errorf("unprivileged ICMP sockets are not permitted for group %d (net.ipv4.ping_group_range = %q), "+
+			"allow the group by sysctl or run with CAP_NET_RAW without --ping.unprivileged: %w", os.Getegid(), groups, err)
+	case errors.Is(err, syscall.EPROTONOSUPPORT), errors.Is(err, syscall.EAFNOSUPPORT):
+		return fmt.Errorf("unprivileged ICMP sockets are not supported by the kernel: %w", err)
+	default:
+		return os.NewSyscallError("socket", err)
+	}
+}
@@ -0,0 +1,35 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestPinger_unprivileged(t *testing.T) {
+	p, err := newPinger("127.0.0.1", "", true)
+	if errors.Is(err, syscall.EACCES) {
+		// not permitted by net.ipv4.ping_group_range, the error has to tell why
+		if !strings.Contains(err.Error(), "ping_group_range") {
+			t.Errorf("expected hint on net.ipv4.ping_group_range, got %q", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Skipf("unprivileged ICMP not available: %v", err)
+	}
+	defer p.Close()
+
+	reply, err := p.Ping(&net.IPAddr{IP: net.ParseIP("127.0.0.1")}, time.Second, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reply.rtt <= 0 {
+		t.Errorf("unexpected rtt %v", reply.rtt)
+	}
+}
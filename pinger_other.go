@@ -0,0 +1,80 @@
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+var (
+	errBindToDevice = errors.New("binding to a network device is not supported on this platform")
+	errSetMark      = errors.New("setting SO_MARK socket option is not supported on this platform")
+)
+
+// SetMark is only supported on Linux
+func (p *pinger) SetMark(mark uint) error {
+	return errSetMark
+}
+
+// SetDontFragment is only supported on Linux
+func (p *pinger) SetDontFragment() error {
+	return errors.New("setting the DF flag is not supported on this platform")
+}
+
+// BindToDevice is only supported on Linux
+func (p *pinger) BindToDevice(device string) error {
+	return errBindToDevice
+}
+
+// bindToDevice is only supported on Linux, the returned control function fails
+func bindToDevice(device string) controlFunc {
+	if device == "" {
+		return nil
+	}
+
+	return func(string, string, syscall.RawConn) error {
+		return errBindToDevice
+	}
+}
+
+// markControl is only supported on Linux, the returned control function fails
+func markControl(mark uint) controlFunc {
+	if mark == 0 {
+		return nil
+	}
+
+	return func(string, string, syscall.RawConn) error {
+		return errSetMark
+	}
+}
+
+// enableRecvErr is only supported on Linux, ICMP error messages referring to
+// UDP probes are not received on other platforms
+func enableRecvErr(c net.PacketConn, v6 bool) error {
+	return nil
+}
+
+// readErrQueue is only supported on Linux
+func readErrQueue(c net.PacketConn, fn func(payload []byte, e *icmpError)) error {
+	return errors.New("reading the error queue of sockets is not supported on this platform")
+}
+
+// trafficClassControl is only supported on Linux, the returned control function fails
+func trafficClassControl(tos int) controlFunc {
+	if tos == 0 {
+		return nil
+	}
+
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("setting the traffic class of TCP probes is not supported on this platform")
+	}
+}
+
+// listenICMPDatagram is only supported on Linux
+func listenICMPDatagram(string, bool) (net.PacketConn, error) {
+	return nil, errors.New("unprivileged ICMP is not supported on this platform")
+}
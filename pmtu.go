@@ -0,0 +1,119 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// header lengths of an echo request without payload
+const (
+	echoHeaderLen4 = 20 + 8 // IPv4 + ICMP
+	echoHeaderLen6 = 40 + 8 // IPv6 + ICMPv6
+)
+
+// sizedProber sends echo requests with payloads of any size
+type sizedProber interface {
+	PayloadSize() uint16
+	PingSize(dst *net.IPAddr, size uint16, timeout time.Duration) (echoReply, error)
+}
+
+// pathMTUResult is the result of a path MTU discovery
+type pathMTUResult struct {
+	mtu       int  // size of the largest IP packet answered
+	blackHole bool // larger packets were dropped without an ICMP error
+	err       error
+}
+
+// pathMTUSearch discovers the path MTU by a binary search over the payload
+// size of echo requests with DF flag, up to the payload size of the prober.
+// Each step sends a single request.
+type pathMTUSearch struct {
+	prober sizedProber
+
+	good    int  // largest payload size answered, -1 if none
+	bad     int  // smallest payload size not answered
+	tooBig  bool // an ICMP error reported the MTU to be exceeded
+	retried bool // the current size is sent again after a timeout
+	mutex   sync.Mutex
+}
+
+func newPathMTUSearch(p sizedProber) *pathMTUSearch {
+	s := &pathMTUSearch{prober: p}
+	s.reset()
+
+	return s
+}
+
+func (s *pathMTUSearch) reset() {
+	s.good, s.bad = -1, int(s.prober.PayloadSize())+1
+	s.tooBig, s.retried = false, false
+}
+
+// next returns the payload size of the next request, the largest size is
+// tried first so an unchanged path MTU is confirmed by a single request
+func (s *pathMTUSearch) next() uint16 {
+	if s.good < 0 && s.bad == int(s.prober.PayloadSize())+1 {
+		return s.prober.PayloadSize()
+	}
+
+	return uint16((s.good + s.bad) / 2)
+}
+
+// step sends the next request of the search and returns the result once the
+// search is finished. If the previous step is still waiting for its reply,
+// nothing is sent.
+func (s *pathMTUSearch) step(dst *net.IPAddr, timeout time.Duration) (pathMTUResult, bool) {
+	if !s.mutex.TryLock() {
+		return pathMTUResult{}, false
+	}
+	defer s.mutex.Unlock()
+
+	size := s.next()
+	_, err := s.prober.PingSize(dst, size, timeout)
+	switch {
+	case err == nil:
+		s.good, s.retried = int(size), false
+	case errors.Is(err, errTooBig):
+		s.bad, s.retried = int(size), false
+		s.tooBig = true
+	case errors.Is(err, errTimeout):
+		if !s.retried {
+			// the request might have been lost regardless of its size
+			s.retried = true
+			return pathMTUResult{}, false
+		}
+		s.bad, s.retried = int(size), false
+	default:
+		s.reset()
+		return pathMTUResult{err: err}, true
+	}
+
+	if s.bad-s.good > 1 {
+		return pathMTUResult{}, false
+	}
+
+	var r pathMTUResult
+	if s.good < 0 {
+		r.err = fmt.Errorf("no reply to any request of %d bytes or less", s.bad)
+	} else {
+		r.mtu = s.good + echoHeaderLen(dst)
+		r.blackHole = s.bad <= int(s.prober.PayloadSize()) && !s.tooBig
+	}
+	s.reset()
+
+	return r, true
+}
+
+// echoHeaderLen returns the length of the headers of an echo request to dst
+func echoHeaderLen(dst *net.IPAddr) int {
+	if dst.IP.To4() == nil {
+		return echoHeaderLen6
+	}
+
+	return echoHeaderLen4
+}
@@ -0,0 +1,78 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// pathMTUFake answers echo requests up to the given MTU
+type pathMTUFake struct {
+	payloadSize uint16
+	mtu         int
+	tooBig      bool // report larger requests by ICMP error instead of dropping them
+	sent        int
+}
+
+func (f *pathMTUFake) PayloadSize() uint16 {
+	return f.payloadSize
+}
+
+func (f *pathMTUFake) PingSize(dst *net.IPAddr, size uint16, _ time.Duration) (echoReply, error) {
+	f.sent++
+	if int(size)+echoHeaderLen(dst) <= f.mtu {
+		return echoReply{}, nil
+	}
+	if f.tooBig {
+		return echoReply{}, errTooBig
+	}
+
+	return echoReply{}, errTimeout
+}
+
+func TestPathMTUSearch(t *testing.T) {
+	dst := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+
+	for _, tt := range []struct {
+		name      string
+		fake      *pathMTUFake
+		mtu       int
+		blackHole bool
+		maxSent   int
+	}{
+		{"unchanged", &pathMTUFake{payloadSize: 1472, mtu: 1500}, 1500, false, 1},
+		{"black hole", &pathMTUFake{payloadSize: 1472, mtu: 1400}, 1400, true, 22},
+		{"fragmentation needed", &pathMTUFake{payloadSize: 1472, mtu: 1280, tooBig: true}, 1280, false, 11},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newPathMTUSearch(tt.fake)
+
+			var r pathMTUResult
+			for finished := false; !finished; {
+				r, finished = s.step(dst, time.Second)
+			}
+
+			if r.err != nil || r.mtu != tt.mtu || r.blackHole != tt.blackHole {
+				t.Errorf("got mtu %d (black hole %v, err %v), want %d (black hole %v)", r.mtu, r.blackHole, r.err, tt.mtu, tt.blackHole)
+			}
+			if tt.fake.sent > tt.maxSent {
+				t.Errorf("expected at most %d requests, got %d", tt.maxSent, tt.fake.sent)
+			}
+		})
+	}
+}
+
+func TestPathMTUSearch_unreachable(t *testing.T) {
+	s := newPathMTUSearch(&pathMTUFake{payloadSize: 1472})
+
+	var r pathMTUResult
+	for finished := false; !finished; {
+		r, finished = s.step(&net.IPAddr{IP: net.ParseIP("2001:db8::1")}, time.Second)
+	}
+
+	if r.err == nil {
+		t.Errorf("expected discovery to fail, got mtu %d", r.mtu)
+	}
+}
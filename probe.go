@@ -0,0 +1,235 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// prober sends a single probe to dst and waits for the response until the
+// timeout expires. Responses received later on are passed to h.
+type prober interface {
+	Ping(dst *net.IPAddr, timeout time.Duration, h extraReplyHandler) (echoReply, error)
+}
+
+// icmpPingers provides the pingers of the ICMP probes, one per socket options
+var icmpPingers *pingerPool
+
+// newProber returns the prober for the probe type and socket options of t
+func newProber(t config.TargetConfig, b socketOptions) (prober, error) {
+	var p prober
+	var err error
+	switch t.ProbeType() {
+	case config.ProbeTCP:
+		p = newTCPProber(t.Port, b)
+	case config.ProbeUDPEcho, config.ProbeTWAMPLight:
+		p, err = newUDPProber(t.ProbeType(), t.Port, b.size, b)
+	case config.ProbeARP:
+		p, err = newNeighborProber(b)
+	case config.ProbeExtendedEcho:
+		p, err = newExtendedEchoProber(t.ProbeInterface, b)
+	default:
+		p, err = icmpPingers.acquire(b)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+// scheduleOf returns the schedule of the monitor probing t, the settings of
+// the target override the global ones
+func scheduleOf(t config.TargetConfig, cfg *config.Config) monitorSchedule {
+	s := monitorSchedule{
+		interval:    cfg.Ping.Interval.Duration(),
+		timeout:     cfg.Ping.Timeout.Duration(),
+		historySize: cfg.Ping.History,
+	}
+
+	if t.Interval > 0 {
+		s.interval = t.Interval.Duration()
+	}
+	if t.Timeout > 0 {
+		s.timeout = t.Timeout.Duration()
+	}
+	if t.History > 0 {
+		s.historySize = t.History
+	}
+
+	s.burst = burstSettings{
+		count:   cfg.Ping.BurstCount,
+		spacing: cfg.Ping.BurstSpacing.Duration(),
+	}
+	if t.BurstCount > 0 {
+		s.burst.count = t.BurstCount
+	}
+	if t.BurstSpacing > 0 {
+		s.burst.spacing = t.BurstSpacing.Duration()
+	}
+	if s.burst.count < 2 {
+		// single requests, regardless of the spacing
+		s.burst = burstSettings{}
+	}
+
+	return s
+}
+
+// traceSettingsOf returns the trace settings of t, tracing is disabled if not enabled for t
+func traceSettingsOf(t config.TargetConfig, cfg *config.Config) traceSettings {
+	if !t.Trace {
+		return traceSettings{}
+	}
+
+	return traceSettings{
+		maxHops:      cfg.Trace.MaxHops,
+		maxAddresses: cfg.Trace.MaxHopAddresses,
+	}
+}
+
+// closeProber releases the resources of p, if any
+func closeProber(p prober) {
+	if c, ok := p.(io.Closer); ok {
+		c.Close()
+	}
+}
+
+func validateProbeConfig(cfg *config.Config) error {
+	if err := validatePayloadPattern(cfg.Ping.Pattern); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for _, t := range cfg.Targets {
+		for name := range t.Labels {
+			if isReservedLabel(name) {
+				return fmt.Errorf("target %s: label %s is reserved by the exporter", t.Addr, name)
+			}
+		}
+
+		switch t.ProbeType() {
+		case config.ProbeICMP:
+			if t.Port != 0 {
+				return fmt.Errorf("target %s: port is only supported by tcp, udp-echo and twamp-light probes", t.Addr)
+			}
+		case config.ProbeTCP:
+			if t.Port == 0 {
+				return fmt.Errorf("target %s: tcp probe requires a port", t.Addr)
+			}
+		case config.ProbeUDPEcho, config.ProbeTWAMPLight:
+		case config.ProbeARP:
+			if t.Port != 0 {
+				return fmt.Errorf("target %s: port is only supported by tcp, udp-echo and twamp-light probes", t.Addr)
+			}
+			if t.Interface == "" && cfg.Ping.Interface == "" {
+				return fmt.Errorf("target %s: arp probe requires an interface", t.Addr)
+			}
+		case config.ProbeExtendedEcho:
+			if t.Port != 0 {
+				return fmt.Errorf("target %s: port is only supported by tcp, udp-echo and twamp-light probes", t.Addr)
+			}
+			if t.ProbeInterface == "" {
+				return fmt.Errorf("target %s: extended-echo probe requires a probe-interface", t.Addr)
+			}
+			if cfg.Ping.Unprivileged {
+				return fmt.Errorf("target %s: extended-echo requires raw ICMP sockets (ping.unprivileged)", t.Addr)
+			}
+		default:
+			return fmt.Errorf("target %s: unknown probe %q, must be icmp, tcp, udp-echo, twamp-light, arp or extended-echo", t.Addr, t.Probe)
+		}
+
+		if t.ProbeInterface != "" && t.ProbeType() != config.ProbeExtendedEcho {
+			return fmt.Errorf("target %s: probe-interface is only supported by extended-echo probes", t.Addr)
+		}
+
+		if t.PathMTU && t.ProbeType() != config.ProbeICMP {
+			return fmt.Errorf("target %s: path-mtu is only supported by icmp probes", t.Addr)
+		}
+
+		if t.Timestamp && t.ProbeType() != config.ProbeICMP {
+			return fmt.Errorf("target %s: timestamp is only supported by icmp probes", t.Addr)
+		}
+		if t.Timestamp && cfg.Ping.Unprivileged {
+			return fmt.Errorf("target %s: timestamp requires raw ICMP sockets (ping.unprivileged)", t.Addr)
+		}
+
+		if t.Trace && (t.ProbeType() == config.ProbeTCP || t.ProbeType() == config.ProbeARP || t.ProbeType() == config.ProbeExtendedEcho) {
+			return fmt.Errorf("target %s: trace is only supported by icmp and udp probes", t.Addr)
+		}
+
+		if err := validateFlows(t, cfg); err != nil {
+			return fmt.Errorf("target %s: %w", t.Addr, err)
+		}
+
+		if t.Interval < 0 || t.Timeout < 0 {
+			return fmt.Errorf("target %s: interval and timeout must not be negative", t.Addr)
+		}
+		if t.History < 0 {
+			return fmt.Errorf("target %s: history-size must not be negative", t.Addr)
+		}
+		if t.Size > 65500 {
+			return fmt.Errorf("target %s: payload-size must be between 0 and 65500", t.Addr)
+		}
+		if err := validatePayloadPattern(t.Pattern); err != nil {
+			return fmt.Errorf("target %s: %w", t.Addr, err)
+		}
+		if t.BurstCount < 0 || t.BurstSpacing < 0 {
+			return fmt.Errorf("target %s: burst-count and burst-spacing must not be negative", t.Addr)
+		}
+		if s := scheduleOf(t, cfg); time.Duration(s.burst.count-1)*s.burst.spacing >= s.interval {
+			return fmt.Errorf("target %s: a burst of %d requests spaced by %v does not fit into the interval of %v", t.Addr, s.burst.count, s.burst.spacing, s.interval)
+		}
+
+		// the series of a target are identified by host, probe type, port, source and DSCP
+		o := socketOptionsOf(t, cfg)
+		id := targetConfigKey(t.Addr, t.ProbeType(), portLabel(t.Port), o.sourceLabel(), o.dscpLabel())
+		if seen[id] {
+			return fmt.Errorf("target %s: probe %s configured more than once for the same port, source and dscp", t.Addr, t.ProbeType())
+		}
+		seen[id] = true
+	}
+
+	return nil
+}
+
+// validateFlows checks the flows of t, flows are not combined with the
+// probes of the target other than its echo requests
+func validateFlows(t config.TargetConfig, cfg *config.Config) error {
+	switch {
+	case t.Flows == 0:
+		return nil
+	case t.Flows < 0 || t.Flows > maxFlows:
+		return fmt.Errorf("flows must be between 0 and %d", maxFlows)
+	}
+
+	switch t.ProbeType() {
+	case config.ProbeICMP:
+		if socketOptionsOf(t, cfg).size < 2 {
+			return fmt.Errorf("flows of icmp probes require a payload-size of at least 2")
+		}
+	case config.ProbeUDPEcho, config.ProbeTWAMPLight:
+	default:
+		return fmt.Errorf("flows are only supported by icmp and udp probes")
+	}
+
+	if t.PathMTU || t.Timestamp || t.Trace {
+		return fmt.Errorf("flows can not be combined with path-mtu, timestamp or trace")
+	}
+
+	return nil
+}
+
+// validatePayloadPattern checks the payload pattern, empty for the default
+func validatePayloadPattern(pattern string) error {
+	switch pattern {
+	case "", config.PayloadRandom, config.PayloadZeros, config.PayloadOnes, config.PayloadDSL:
+		return nil
+	default:
+		return fmt.Errorf("unknown payload-pattern %q, must be random, zeros, ones or dsl", pattern)
+	}
+}
@@ -0,0 +1,92 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestValidateProbeConfig_ports(t *testing.T) {
+	cfg := validConfig()
+	cfg.Targets = []config.TargetConfig{
+		{Addr: "example.com", Probe: config.ProbeTCP, Port: 80},
+		{Addr: "example.com", Probe: config.ProbeTCP, Port: 443},
+	}
+	if err := validateProbeConfig(cfg); err != nil {
+		t.Errorf("unexpected error for several ports of a host: %v", err)
+	}
+
+	cfg.Targets = append(cfg.Targets, config.TargetConfig{Addr: "example.com", Probe: config.ProbeTCP, Port: 443})
+	if err := validateProbeConfig(cfg); err == nil {
+		t.Error("expected error for the same port configured twice")
+	}
+
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com", Port: 443}}
+	if err := validateProbeConfig(cfg); err == nil || !strings.Contains(err.Error(), "udp-echo") {
+		t.Errorf("expected error naming the probes supporting ports, got %v", err)
+	}
+}
+
+func TestValidateProbeConfig_reservedLabels(t *testing.T) {
+	cfg := validConfig()
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com", Labels: map[string]string{"asn": "15169"}}}
+	if err := validateProbeConfig(cfg); err != nil {
+		t.Errorf("unexpected error for custom label: %v", err)
+	}
+
+	for _, name := range []string{"target", "flow", "port", "hop", "type"} {
+		cfg.Targets = []config.TargetConfig{{Addr: "example.com", Labels: map[string]string{name: "x"}}}
+		if err := validateProbeConfig(cfg); err == nil || !strings.Contains(err.Error(), "reserved") {
+			t.Errorf("expected error for reserved label %s, got %v", name, err)
+		}
+	}
+}
+
+func TestNewProber_icmpPingerOfOptions(t *testing.T) {
+	prev := icmpPingers
+	defer func() {
+		icmpPingers = prev
+	}()
+	icmpPingers = newPingerPool(func(o socketOptions) (*pinger, error) {
+		return &pinger{stop: make(chan struct{})}, nil
+	})
+
+	// a target with the global options of a reloaded config is sent by the
+	// pinger of these options, not by the one of the startup config
+	cfg := validConfig()
+	cfg.Ping.SourceAddress = "192.0.2.10"
+	b := socketOptionsOf(config.TargetConfig{}, cfg)
+
+	p, err := newProber(config.TargetConfig{Addr: "example.com"}, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeProber(p)
+
+	sp, ok := p.(*sharedPinger)
+	if !ok {
+		t.Fatalf("expected pooled pinger, got %T", p)
+	}
+	if sp.opts != b {
+		t.Errorf("expected pinger with options %+v, got %+v", b, sp.opts)
+	}
+}
+
+func TestValidateProbeConfig_traceUnprivileged(t *testing.T) {
+	cfg := validConfig()
+	cfg.Ping.Unprivileged = true
+
+	// time exceeded messages are read from the error queue of datagram sockets
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com", Trace: true}}
+	if err := validateProbeConfig(cfg); err != nil {
+		t.Errorf("unexpected error for trace with unprivileged sockets: %v", err)
+	}
+
+	cfg.Targets = []config.TargetConfig{{Addr: "example.com", Timestamp: true}}
+	if err := validateProbeConfig(cfg); err == nil {
+		t.Error("expected error for timestamp with unprivileged sockets")
+	}
+}
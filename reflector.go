@@ -0,0 +1,176 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// peerFilter is the list of prefixes of the peers answered by the reflector
+type peerFilter []netip.Prefix
+
+// parsePeerFilter parses the prefixes of the allowed peers, at least one is required
+func parsePeerFilter(prefixes []string) (peerFilter, error) {
+	if len(prefixes) == 0 {
+		return nil, errors.New("no allowed peers specified")
+	}
+
+	f := make(peerFilter, 0, len(prefixes))
+	for _, s := range prefixes {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer prefix: %w", err)
+		}
+		f = append(f, p.Masked())
+	}
+
+	return f, nil
+}
+
+// allows checks if the reflector answers packets of addr
+func (f peerFilter) allows(addr net.Addr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	ip := a.AddrPort().Addr().Unmap()
+	for _, p := range f {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// runReflector answers UDP echo (RFC 862) and TWAMP-Light (RFC 5357) test
+// packets of the allowed peers on the given addresses (empty to disable)
+func runReflector(echoAddress, twampAddress string, allowedPeers []string) error {
+	peers, err := parsePeerFilter(allowedPeers)
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	listening := false
+
+	for _, l := range []struct {
+		mode    string
+		address string
+	}{
+		{config.ProbeUDPEcho, echoAddress},
+		{config.ProbeTWAMPLight, twampAddress},
+	} {
+		if l.address == "" {
+			continue
+		}
+
+		conns, err := listenUDP(l.address)
+		if err != nil {
+			return fmt.Errorf("failed to listen for %s packets on %s: %w", l.mode, l.address, err)
+		}
+
+		for _, c := range conns {
+			log.Infof("answering %s packets on %s", l.mode, c.LocalAddr())
+			wg.Go(func() {
+				reflect(c, l.mode, peers)
+			})
+		}
+		listening = true
+	}
+
+	if !listening {
+		return errors.New("no reflector address specified")
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// listenUDP listens on address, on both IP versions if no host is given
+func listenUDP(address string) ([]net.PacketConn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	if host != "" {
+		c, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return nil, err
+		}
+		return []net.PacketConn{c}, nil
+	}
+
+	var conns []net.PacketConn
+	for _, a := range []struct{ network, address string }{
+		{"udp4", net.JoinHostPort("0.0.0.0", port)},
+		{"udp6", net.JoinHostPort("::", port)},
+	} {
+		// an IP version might not be available on the host
+		c, lerr := net.ListenPacket(a.network, a.address)
+		if lerr != nil {
+			err = lerr
+			continue
+		}
+		conns = append(conns, c)
+	}
+
+	if len(conns) == 0 {
+		return nil, err
+	}
+
+	return conns, nil
+}
+
+// reflect sends the packets of the allowed peers received on c back to their
+// source. A reply is never larger than the packet it answers, so the reflector
+// can not be used to amplify traffic.
+func reflect(c net.PacketConn, mode string, peers peerFilter) {
+	v6 := c.LocalAddr().(*net.UDPAddr).IP.To4() == nil
+	read := newTTLReader(c, v6)
+	b := make([]byte, 65536)
+
+	for {
+		n, ttl, addr, err := read(b)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+
+			log.Errorf("failed to receive %s packets on %s: %v", mode, c.LocalAddr(), err)
+			return
+		}
+
+		if !peers.allows(addr) {
+			continue
+		}
+
+		received := time.Now()
+		reply := b[:n]
+		if mode == config.ProbeTWAMPLight {
+			// shorter requests would get larger replies
+			if n < twampReplyLen {
+				continue
+			}
+			reply = marshalTWAMPReply(b[:n], received, time.Now(), ttl)
+		}
+
+		if _, err := c.WriteTo(reply, addr); err != nil {
+			log.Debugf("failed to answer %s packet of %s: %v", mode, addr, err)
+		}
+	}
+}
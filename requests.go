@@ -0,0 +1,299 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// finished requests are kept this long to detect late and duplicate
+	// replies, unless their sequence number is needed for a new request
+	requestRetention = time.Minute
+)
+
+var errNoSequence = errors.New("no free sequence number, too many requests in flight")
+
+// extraReplyHandler gets notified about replies received for an echo request
+// that already has been answered or timed out
+type extraReplyHandler interface {
+	duplicateReply(rtt time.Duration)
+	lateReply(seq uint16, rtt time.Duration)
+}
+
+// echoReply is the reply to an echo request
+type echoReply struct {
+	seq     uint16
+	rtt     time.Duration
+	ttl     int          // TTL or hop limit the reply arrived with, 0 if unknown
+	hop     net.IP       // router answering a TTL limited request with time exceeded, nil for the destination
+	payload payloadState // the echoed payload compared with the payload of the request
+
+	timestamps icmpTimestamps   // of an ICMP timestamp reply
+	mac        net.HardwareAddr // of an ARP or NDP reply, nil if unknown
+	iface      *interfaceState  // of an ICMP extended echo reply, nil if none
+}
+
+// echoRequest is a pending or recently finished echo request
+type echoRequest struct {
+	seq      uint16
+	dst      net.IP
+	payload  []byte // verified against the echoed payload, nil if not echoed
+	traced   bool   // TTL limited, time exceeded messages are the expected answer
+	sent     time.Time
+	handler  extraReplyHandler
+	reply    chan echoReply
+	err      error
+	finished bool // answered, failed or timed out
+	timedOut bool
+	late     bool // a reply has been received after the timeout
+}
+
+// requestTable correlates echo requests with their replies by sequence number
+type requestTable struct {
+	requests map[uint16]*echoRequest
+	size     int // number of sequence numbers
+	sequence int // last sequence number assigned
+	mutex    sync.Mutex
+}
+
+func newRequestTable() *requestTable {
+	return newRequestTableOfSize(1 << 16)
+}
+
+// newRequestTableOfSize returns a table for size sequence numbers, e.g. 256
+// for 8 bit sequence numbers
+func newRequestTableOfSize(size int) *requestTable {
+	return &requestTable{
+		requests: make(map[uint16]*echoRequest),
+		size:     size,
+	}
+}
+
+// add registers a new request with the next sequence number not in use, it has
+// to be added before being sent. The payload of the replies is compared with
+// payload, unless it is nil. ttl is the TTL (or hop limit) the request is sent
+// with, 0 for the default.
+func (r *requestTable) add(dst net.IP, payload []byte, ttl int, h extraReplyHandler) (*echoRequest, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// sequence numbers wrap around, requests still pending must not be
+	// replaced. Finished requests are kept to detect late replies, if there is
+	// no free sequence number the oldest of them is replaced.
+	var oldest *echoRequest
+	for range r.size {
+		r.sequence = (r.sequence + 1) % r.size
+		seq := uint16(r.sequence)
+		prev, found := r.requests[seq]
+		if !found {
+			return r.insert(seq, dst, payload, ttl, h), nil
+		}
+		if prev.finished && (oldest == nil || prev.sent.Before(oldest.sent)) {
+			oldest = prev
+		}
+	}
+
+	if oldest == nil {
+		return nil, errNoSequence
+	}
+
+	r.sequence = int(oldest.seq)
+	return r.insert(oldest.seq, dst, payload, ttl, h), nil
+}
+
+// insert needs to be called with the mutex of the table held
+func (r *requestTable) insert(seq uint16, dst net.IP, payload []byte, ttl int, h extraReplyHandler) *echoRequest {
+	req := &echoRequest{
+		seq:     seq,
+		dst:     dst,
+		payload: payload,
+		traced:  ttl > 0,
+		handler: h,
+		reply:   make(chan echoReply, 1),
+	}
+	r.requests[seq] = req
+	return req
+}
+
+// markSent records the time req is sent
+func (r *requestTable) markSent(req *echoRequest) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	req.sent = time.Now()
+}
+
+// remove drops a request which could not be sent
+func (r *requestTable) remove(seq uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.requests, seq)
+}
+
+// wait waits for the reply to req until the timeout expires
+func (r *requestTable) wait(req *echoRequest, timeout time.Duration) (echoReply, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case reply, ok := <-req.reply:
+		if !ok {
+			// the request failed, e.g. by an ICMP error message
+			return echoReply{}, req.err
+		}
+		return reply, nil
+	case <-timer.C:
+	}
+
+	r.mutex.Lock()
+	if req.finished {
+		// reply or error has been received concurrently
+		r.mutex.Unlock()
+		return awaitFinished(req)
+	}
+	req.finished = true
+	req.timedOut = true
+	r.mutex.Unlock()
+
+	return echoReply{}, errTimeout
+}
+
+func awaitFinished(req *echoRequest) (echoReply, error) {
+	reply, ok := <-req.reply
+	if !ok {
+		return echoReply{}, req.err
+	}
+
+	return reply, nil
+}
+
+// handleReply passes a reply received from src with the echoed payload to
+// the matching request
+func (r *requestTable) handleReply(seq uint16, src net.IP, ttl int, payload []byte, received time.Time) {
+	r.mutex.Lock()
+	req, found := r.requests[seq]
+	if !found || !req.dst.Equal(src) {
+		r.mutex.Unlock()
+		return
+	}
+
+	rtt := received.Sub(req.sent)
+	late, duplicate := false, false
+	switch {
+	case !req.finished:
+		req.finished = true
+		req.reply <- echoReply{seq: seq, rtt: rtt, ttl: ttl, payload: verifyPayload(req.payload, payload)}
+	case req.timedOut && !req.late:
+		req.late = true
+		late = true
+	case req.err == nil:
+		duplicate = true
+	}
+	r.mutex.Unlock()
+
+	// the handler is called after unlocking, since it may block
+	if req.handler == nil {
+		return
+	}
+	if late {
+		req.handler.lateReply(seq, rtt)
+	}
+	if duplicate {
+		req.handler.duplicateReply(rtt)
+	}
+}
+
+// handleTimeExceeded passes an ICMP time exceeded message to the matching
+// pending request. It is the answer of the reporting hop to TTL limited
+// requests, other requests fail (e.g. due to a routing loop).
+func (r *requestTable) handleTimeExceeded(seq uint16, e *icmpError, received time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	req, found := r.requests[seq]
+	if !found || req.finished {
+		return
+	}
+
+	if !req.traced {
+		failRequest(req, e)
+		return
+	}
+
+	req.finished = true
+	req.reply <- echoReply{seq: seq, rtt: received.Sub(req.sent), hop: e.reporter}
+}
+
+// handleQueryReply passes the reply to an ICMP query other than echo (e.g.
+// timestamp) received from src to the matching pending request, sequence
+// number and RTT of the reply are set from the request
+func (r *requestTable) handleQueryReply(seq uint16, src net.IP, reply echoReply, received time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	req, found := r.requests[seq]
+	if !found || req.finished || !req.dst.Equal(src) {
+		return
+	}
+
+	req.finished = true
+	reply.seq = seq
+	reply.rtt = received.Sub(req.sent)
+	req.reply <- reply
+}
+
+// fail finishes the pending request with the given sequence number with an error
+func (r *requestTable) fail(seq uint16, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if req, found := r.requests[seq]; found {
+		failRequest(req, err)
+	}
+}
+
+// failAll finishes all pending requests with an error
+func (r *requestTable) failAll(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, req := range r.requests {
+		failRequest(req, err)
+	}
+}
+
+// failRequest needs to be called with the mutex of the table held
+func failRequest(req *echoRequest, err error) {
+	if req.finished {
+		return
+	}
+
+	req.finished = true
+	req.err = err
+	close(req.reply)
+}
+
+// expire drops finished requests after the retention period until stop is closed
+func (r *requestTable) expire(stop <-chan struct{}) {
+	tick := time.NewTicker(requestRetention / 4)
+	defer tick.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+
+		r.mutex.Lock()
+		for seq, req := range r.requests {
+			if req.finished && time.Since(req.sent) > requestRetention {
+				delete(r.requests, seq)
+			}
+		}
+		r.mutex.Unlock()
+	}
+}
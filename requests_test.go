@@ -0,0 +1,192 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	ip4 "golang.org/x/net/ipv4"
+)
+
+type replyCounter struct {
+	duplicates int
+	late       int
+}
+
+func (c *replyCounter) duplicateReply(time.Duration) {
+	c.duplicates++
+}
+
+func (c *replyCounter) lateReply(uint16, time.Duration) {
+	c.late++
+}
+
+func TestRequestTable_handleReply(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	h := &replyCounter{}
+	r := newRequestTable()
+
+	answered := &echoRequest{seq: 1, dst: dst, sent: time.Now(), handler: h, reply: make(chan echoReply, 1)}
+	timedOut := &echoRequest{seq: 2, dst: dst, sent: time.Now(), handler: h, reply: make(chan echoReply, 1), finished: true, timedOut: true}
+	r.requests[1] = answered
+	r.requests[2] = timedOut
+
+	r.handleReply(1, dst, 64, nil, time.Now())
+	select {
+	case r := <-answered.reply:
+		if r.seq != 1 {
+			t.Errorf("expected reply to seq 1, got %d", r.seq)
+		}
+	default:
+		t.Fatal("expected reply to be delivered")
+	}
+
+	r.handleReply(1, dst, 64, nil, time.Now())
+	r.handleReply(2, dst, 64, nil, time.Now())
+	r.handleReply(2, dst, 64, nil, time.Now())
+	r.handleReply(2, net.ParseIP("192.0.2.2"), 64, nil, time.Now()) // wrong source
+	r.handleReply(3, dst, 64, nil, time.Now())                      // unknown request
+
+	if h.duplicates != 2 {
+		t.Errorf("expected 2 duplicates, got %d", h.duplicates)
+	}
+	if h.late != 1 {
+		t.Errorf("expected 1 late reply, got %d", h.late)
+	}
+}
+
+func TestRequestTable_fail(t *testing.T) {
+	r := newRequestTable()
+	req, _ := r.add(net.ParseIP("192.0.2.1"), nil, 0, nil)
+	r.markSent(req)
+
+	errUnreachable := errors.New("destination unreachable")
+	r.fail(req.seq, errUnreachable)
+
+	if _, err := r.wait(req, time.Second); !errors.Is(err, errUnreachable) {
+		t.Errorf("expected the error of the failed request, got %v", err)
+	}
+}
+
+func TestRequestTable_handleTimeExceeded(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	e := &icmpError{typ: ip4.ICMPTypeTimeExceeded, reporter: net.ParseIP("198.51.100.1")}
+
+	r := newRequestTable()
+	traced, _ := r.add(dst, nil, 3, nil)
+	r.markSent(traced)
+	looped, _ := r.add(dst, nil, 0, nil)
+	r.markSent(looped)
+
+	r.handleTimeExceeded(traced.seq, e, time.Now())
+	r.handleTimeExceeded(looped.seq, e, time.Now())
+
+	reply, err := r.wait(traced, time.Second)
+	if err != nil || !reply.hop.Equal(e.reporter) {
+		t.Errorf("expected reply from hop %s, got %v (%v)", e.reporter, reply.hop, err)
+	}
+
+	var icmpErr *icmpError
+	if _, err := r.wait(looped, time.Second); !errors.As(err, &icmpErr) || !errors.Is(err, errTTLExceeded) {
+		t.Errorf("expected time exceeded error, got %v", err)
+	}
+}
+
+func TestRequestTable_add_skipsSequenceInUse(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	r := newRequestTableOfSize(4)
+
+	pending, _ := r.add(dst, nil, 0, nil)
+	r.add(dst, nil, 0, nil)
+	r.add(dst, nil, 0, nil)
+	r.remove(pending.seq + 1)
+	r.remove(pending.seq + 2)
+
+	// the sequence numbers wrap around to the one still pending
+	var seqs []uint16
+	for range 3 {
+		req, err := r.add(dst, nil, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		seqs = append(seqs, req.seq)
+	}
+	if slices.Contains(seqs, pending.seq) {
+		t.Errorf("sequence number %d of pending request assigned again: %v", pending.seq, seqs)
+	}
+	if r.requests[pending.seq] != pending {
+		t.Error("pending request has been replaced")
+	}
+
+	if _, err := r.add(dst, nil, 0, nil); !errors.Is(err, errNoSequence) {
+		t.Errorf("expected error without free sequence number, got %v", err)
+	}
+}
+
+func TestRequestTable_add_replacesOldestFinished(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	r := newRequestTableOfSize(4)
+
+	pending, _ := r.add(dst, nil, 0, nil)
+	r.markSent(pending)
+
+	// more requests than sequence numbers, each answered within the retention
+	for i := range 20 {
+		req, err := r.add(dst, nil, 0, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if req.seq == pending.seq {
+			t.Fatalf("request %d: sequence number %d of pending request assigned again", i, req.seq)
+		}
+
+		r.markSent(req)
+		r.handleReply(req.seq, dst, 64, nil, time.Now())
+	}
+
+	if r.requests[pending.seq] != pending {
+		t.Error("pending request has been replaced")
+	}
+
+	// the oldest finished request is replaced first
+	oldest := r.requests[uint16((r.sequence+2)%4)]
+	if oldest == pending {
+		oldest = r.requests[uint16((r.sequence+3)%4)]
+	}
+	oldest.sent = oldest.sent.Add(-time.Hour)
+
+	req, err := r.add(dst, nil, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.seq != oldest.seq {
+		t.Errorf("expected sequence number %d of the oldest finished request, got %d", oldest.seq, req.seq)
+	}
+}
+
+func TestRequestTable_wait(t *testing.T) {
+	dst := net.ParseIP("192.0.2.1")
+	h := &replyCounter{}
+	r := newRequestTable()
+
+	answered, _ := r.add(dst, nil, 0, h)
+	r.markSent(answered)
+	go r.handleReply(answered.seq, dst, 64, nil, time.Now())
+	if reply, err := r.wait(answered, time.Second); err != nil || reply.seq != answered.seq {
+		t.Errorf("expected reply to seq %d, got %d (%v)", answered.seq, reply.seq, err)
+	}
+
+	timedOut, _ := r.add(dst, nil, 0, h)
+	r.markSent(timedOut)
+	if _, err := r.wait(timedOut, time.Millisecond); !errors.Is(err, errTimeout) {
+		t.Errorf("expected timeout, got %v", err)
+	}
+	r.handleReply(timedOut.seq, dst, 64, nil, time.Now())
+	if h.late != 1 {
+		t.Errorf("expected 1 late reply, got %d", h.late)
+	}
+}
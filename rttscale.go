@@ -26,6 +26,15 @@ func rttUnitFromString(s string) rttUnit {
 	}
 }
 
+// millisOnly returns the unit without seconds, rttInvalid if only seconds are selected
+func (u rttUnit) millisOnly() rttUnit {
+	if u == rttInMills || u == rttBoth {
+		return rttInMills
+	}
+
+	return rttInvalid
+}
+
 type scaledMetrics struct {
 	Millis  *prometheus.Desc
 	Seconds *prometheus.Desc
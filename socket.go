@@ -0,0 +1,313 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// socketOptions are the settings of the sockets the probes of a target are
+// sent from: source address, network device (interface or VRF), traffic class,
+// firewall mark, payload size and pattern and DF flag
+type socketOptions struct {
+	source  string // normalized IP address, empty for any
+	device  string
+	tos     int    // TOS (IPv4) or traffic class (IPv6)
+	mark    uint   // SO_MARK, 0 for none
+	size    uint16 // payload size of ICMP and UDP probes
+	pattern string // payload pattern of ICMP and UDP probes, see config.PayloadRandom
+	df      bool   // set the DF flag, ICMP only
+}
+
+// socketOptionsOf returns the effective socket options of t, the settings of
+// the target override the global ones
+func socketOptionsOf(t config.TargetConfig, cfg *config.Config) socketOptions {
+	var o socketOptions
+
+	source := cfg.Ping.SourceAddress
+	if t.SourceAddress != "" {
+		source = t.SourceAddress
+	}
+	if ip := net.ParseIP(source); ip != nil {
+		o.source = ip.String()
+	}
+
+	for _, dev := range []string{t.Interface, t.VRF, cfg.Ping.Interface, cfg.Ping.VRF} {
+		if dev != "" {
+			o.device = dev
+			break
+		}
+	}
+
+	if tos, ok := t.TrafficClass(); ok {
+		o.tos = tos
+	} else if tos, ok := cfg.Ping.TrafficClass(); ok {
+		o.tos = tos
+	}
+
+	o.mark = cfg.Ping.FirewallMark
+	if t.FirewallMark > 0 {
+		o.mark = t.FirewallMark
+	}
+
+	o.size = cfg.Ping.Size
+	if t.Size > 0 {
+		o.size = t.Size
+	}
+
+	o.pattern = cfg.Ping.Pattern
+	if t.Pattern != "" {
+		o.pattern = t.Pattern
+	}
+
+	// the path MTU is probed with DF echo requests
+	o.df = t.PathMTU
+
+	return o
+}
+
+// sourceLabel returns the source address, followed by % and the device if bound to one
+func (o socketOptions) sourceLabel() string {
+	if o.device == "" {
+		return o.source
+	}
+
+	return o.source + "%" + o.device
+}
+
+// dscpLabel returns the DSCP of the traffic class
+func (o socketOptions) dscpLabel() string {
+	return strconv.Itoa(o.tos >> 2)
+}
+
+// sourceIP returns the source address, nil if not bound to an address
+func (o socketOptions) sourceIP() net.IP {
+	return net.ParseIP(o.source)
+}
+
+// permits checks if addr can be probed from the source address
+func (o socketOptions) permits(addr net.IPAddr) bool {
+	src := o.sourceIP()
+	if src == nil {
+		return true
+	}
+
+	return (src.To4() == nil) == (addr.IP.To4() == nil)
+}
+
+func validateSocketConfig(cfg *config.Config) error {
+	if err := validateBinding(cfg.Ping.BindingConfig); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+	if err := validateTrafficClass(cfg.Ping.TrafficClassConfig); err != nil {
+		return fmt.Errorf("ping: %w", err)
+	}
+
+	for _, t := range cfg.Targets {
+		if err := validateBinding(t.BindingConfig); err != nil {
+			return fmt.Errorf("target %s: %w", t.Addr, err)
+		}
+		if err := validateTrafficClass(t.TrafficClassConfig); err != nil {
+			return fmt.Errorf("target %s: %w", t.Addr, err)
+		}
+	}
+
+	return nil
+}
+
+func validateBinding(b config.BindingConfig) error {
+	if b.SourceAddress != "" && net.ParseIP(b.SourceAddress) == nil {
+		return fmt.Errorf("source-address %q is not an IP address", b.SourceAddress)
+	}
+
+	if b.Interface != "" && b.VRF != "" {
+		return fmt.Errorf("interface and vrf are mutually exclusive, an interface implies its VRF")
+	}
+
+	return nil
+}
+
+func validateTrafficClass(c config.TrafficClassConfig) error {
+	if c.DSCP != nil && c.TOS != nil {
+		return fmt.Errorf("dscp and tos are mutually exclusive")
+	}
+
+	if c.DSCP != nil && *c.DSCP > 63 {
+		return fmt.Errorf("dscp must be between 0 and 63")
+	}
+
+	return nil
+}
+
+// newBoundPinger creates a pinger sending with the given socket options
+func newBoundPinger(o socketOptions, cfg *config.Config) (*pinger, error) {
+	bind4, bind6, err := availableBindAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	if src := o.sourceIP(); src != nil {
+		if src.To4() != nil {
+			bind4, bind6 = o.source, ""
+		} else {
+			bind4, bind6 = "", o.source
+		}
+	}
+
+	// an unprivileged socket might not be permitted for both IP versions, so disabled ones are skipped
+	if cfg.Options.DisableIPv4 {
+		bind4 = ""
+	}
+	if cfg.Options.DisableIPv6 {
+		bind6 = ""
+	}
+
+	pinger, err := newPinger(bind4, bind6, cfg.Ping.Unprivileged)
+	if err != nil {
+		return nil, err
+	}
+
+	pinger.SetPayload(o.size, o.pattern)
+
+	if o.mark > 0 {
+		if err := pinger.SetMark(o.mark); err != nil {
+			pinger.Close()
+			return nil, fmt.Errorf("failed to set fwmark: %w", err)
+		}
+	}
+
+	if o.device != "" {
+		if err := pinger.BindToDevice(o.device); err != nil {
+			pinger.Close()
+			return nil, fmt.Errorf("failed to bind to device %s: %w", o.device, err)
+		}
+	}
+
+	if o.tos != 0 {
+		if err := pinger.SetTrafficClass(o.tos); err != nil {
+			pinger.Close()
+			return nil, fmt.Errorf("failed to set traffic class: %w", err)
+		}
+	}
+
+	if o.df {
+		if err := pinger.SetDontFragment(); err != nil {
+			pinger.Close()
+			return nil, fmt.Errorf("failed to set DF flag: %w", err)
+		}
+	}
+
+	return pinger, nil
+}
+
+// controlFunc is called by dialers and listeners on the raw socket before connecting or binding
+type controlFunc func(network, address string, c syscall.RawConn) error
+
+// chainControl returns a controlFunc calling the non-nil control functions in order
+func chainControl(fns ...controlFunc) controlFunc {
+	var chain []controlFunc
+	for _, fn := range fns {
+		if fn != nil {
+			chain = append(chain, fn)
+		}
+	}
+
+	if len(chain) == 0 {
+		return nil
+	}
+
+	return func(network, address string, c syscall.RawConn) error {
+		for _, fn := range chain {
+			if err := fn(network, address, c); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+}
+
+// availableBindAddresses returns the wildcard addresses of the IP versions
+// available on the host
+func availableBindAddresses() (bind4, bind6 string, err error) {
+	if ln, err := net.Listen("tcp4", "127.0.0.1:0"); err == nil {
+		// ipv4 enabled
+		if err := ln.Close(); err != nil {
+			return "", "", fmt.Errorf("failed to close tcp4 listener: %w", err)
+		}
+		bind4 = "0.0.0.0"
+	}
+	if ln, err := net.Listen("tcp6", "[::1]:0"); err == nil {
+		// ipv6 enabled
+		if err := ln.Close(); err != nil {
+			return "", "", fmt.Errorf("failed to close tcp6 listener: %w", err)
+		}
+		bind6 = "::"
+	}
+
+	return bind4, bind6, nil
+}
+
+// pingerPool shares the pingers among the targets with the same socket options
+type pingerPool struct {
+	create  func(o socketOptions) (*pinger, error)
+	pingers map[socketOptions]*sharedPinger
+	mutex   sync.Mutex
+}
+
+// sharedPinger is a pinger used by one or more targets, it is closed as soon
+// as all of them released it
+type sharedPinger struct {
+	*pinger
+	pool *pingerPool
+	opts socketOptions
+	refs int
+}
+
+func newPingerPool(create func(o socketOptions) (*pinger, error)) *pingerPool {
+	return &pingerPool{
+		create:  create,
+		pingers: make(map[socketOptions]*sharedPinger),
+	}
+}
+
+// acquire returns the pinger of the socket options, which needs to be closed by the caller
+func (p *pingerPool) acquire(o socketOptions) (*sharedPinger, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if s, found := p.pingers[o]; found {
+		s.refs++
+		return s, nil
+	}
+
+	pinger, err := p.create(o)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &sharedPinger{pinger: pinger, pool: p, opts: o, refs: 1}
+	p.pingers[o] = s
+	return s, nil
+}
+
+// Close releases the pinger
+func (s *sharedPinger) Close() error {
+	s.pool.mutex.Lock()
+	defer s.pool.mutex.Unlock()
+
+	s.refs--
+	if s.refs > 0 {
+		return nil
+	}
+
+	delete(s.pool.pingers, s.opts)
+	s.pinger.Close()
+	return nil
+}
@@ -0,0 +1,90 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestSocketOptionsOf(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Ping.SourceAddress = "2001:db8::0001"
+	cfg.Ping.VRF = "vrf-red"
+
+	if b := socketOptionsOf(config.TargetConfig{}, cfg); b.sourceLabel() != "2001:db8::1%vrf-red" {
+		t.Errorf("unexpected global source %q", b.sourceLabel())
+	}
+
+	tc := config.TargetConfig{Addr: "192.0.2.1"}
+	tc.SourceAddress = "192.0.2.254"
+	tc.Interface = "eth1"
+	if b := socketOptionsOf(tc, cfg); b.sourceLabel() != "192.0.2.254%eth1" {
+		t.Errorf("unexpected target source %q", b.sourceLabel())
+	}
+
+	if err := validateSocketConfig(cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cfg.Ping.Interface = "eth0"
+	if err := validateSocketConfig(cfg); err == nil {
+		t.Error("expected error for interface and vrf")
+	}
+}
+
+func TestSocketOptionsOf_trafficClass(t *testing.T) {
+	dscp, tos := uint8(46), uint8(0x10)
+	cfg := &config.Config{}
+	cfg.Ping.TOS = &tos
+
+	if o := socketOptionsOf(config.TargetConfig{}, cfg); o.tos != 0x10 || o.dscpLabel() != "4" {
+		t.Errorf("unexpected global traffic class %d (dscp %s)", o.tos, o.dscpLabel())
+	}
+
+	tc := config.TargetConfig{Addr: "192.0.2.1"}
+	tc.DSCP = &dscp
+	if o := socketOptionsOf(tc, cfg); o.tos != 184 || o.dscpLabel() != "46" {
+		t.Errorf("unexpected target traffic class %d (dscp %s)", o.tos, o.dscpLabel())
+	}
+
+	if err := validateSocketConfig(cfg); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	cfg.Ping.DSCP = &dscp
+	if err := validateSocketConfig(cfg); err == nil {
+		t.Error("expected error for dscp and tos")
+	}
+
+	cfg.Ping.TOS = nil
+	tooLarge := uint8(64)
+	cfg.Targets = []config.TargetConfig{{Addr: "192.0.2.1"}}
+	cfg.Targets[0].DSCP = &tooLarge
+	if err := validateSocketConfig(cfg); err == nil {
+		t.Error("expected error for dscp 64")
+	}
+}
+
+func TestPingerPool(t *testing.T) {
+	created := 0
+	pool := newPingerPool(func(socketOptions) (*pinger, error) {
+		created++
+		return &pinger{stop: make(chan struct{})}, nil
+	})
+
+	b := socketOptions{device: "eth1"}
+	p1, _ := pool.acquire(b)
+	p2, _ := pool.acquire(b)
+	if p1 != p2 || created != 1 {
+		t.Fatalf("expected pinger to be shared, created %d", created)
+	}
+
+	p1.Close()
+	if _, found := pool.pingers[b]; !found {
+		t.Error("expected pinger to be kept while in use")
+	}
+	p2.Close()
+	if _, found := pool.pingers[b]; found {
+		t.Error("expected pinger to be closed")
+	}
+}
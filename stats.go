@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"maps"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// targetStats is the state accumulated from the results of a monitored target
+type targetStats struct {
+	rttHistogram       prometheus.Histogram
+	rttHistogramLayout histogramLayout
+
+	packetsSent     uint64
+	packetsReceived uint64
+
+	// reasons of lost packets
+	timeouts           uint64
+	sendErrors         uint64 // local errors, e.g. no route to host
+	icmpErrors         map[icmpErrorKey]uint64
+	connectionsRefused uint64 // TCP probes answered with a reset
+
+	lastReply       time.Time
+	consecutiveLost uint64
+	replyTTL        int             // TTL or hop limit of the latest reply, 0 if unknown
+	mac             string          // link layer address of the latest ARP or NDP reply
+	iface           *interfaceState // of the latest extended echo reply, nil if none
+
+	duplicateReplies uint64
+	lateReplies      uint64
+	reorderedReplies uint64
+	lateDelay        prometheus.Histogram
+
+	corruptedReplies uint64 // echoed payload differs from the request
+	truncatedReplies uint64 // echoed payload shorter than the request
+
+	pathMTU           int // latest discovered path MTU, 0 if unknown
+	pathMTUFailures   uint64
+	pathMTUBlackHoles uint64
+
+	oneWayDelays *oneWayDelays // of the latest ICMP timestamp reply, nil if none
+
+	bursts              uint64
+	burstsLost          uint64 // bursts without any reply
+	burstsPartiallyLost uint64 // bursts with some of the requests unanswered
+}
+
+// icmpErrorKey identifies the ICMP errors of a type and code sent by a reporter
+type icmpErrorKey struct {
+	typ      string
+	code     int
+	reporter string
+}
+
+func (s *targetStats) packetsLost() uint64 {
+	return s.packetsSent - s.packetsReceived
+}
+
+// hopCount estimates the number of hops to the target by assuming the reply
+// started with the nearest common initial TTL
+func (s *targetStats) hopCount() int {
+	for _, initial := range []int{64, 128, 255} {
+		if s.replyTTL <= initial {
+			return initial - s.replyTTL
+		}
+	}
+
+	return 0
+}
+
+// up reports whether the latest echo request has been answered
+func (s *targetStats) up() bool {
+	return s.packetsSent > 0 && s.consecutiveLost == 0
+}
+
+// targetStatsSet holds the stats of each monitored target (by monitor key)
+type targetStatsSet struct {
+	stats map[string]*targetStats
+	mutex sync.Mutex
+}
+
+func newTargetStatsSet() *targetStatsSet {
+	return &targetStatsSet{
+		stats: make(map[string]*targetStats),
+	}
+}
+
+// statsOf returns the stats of the given key, created if nothing was observed
+// yet. The caller has to hold the mutex.
+func (s *targetStatsSet) statsOf(key string) *targetStats {
+	st, found := s.stats[key]
+	if !found {
+		st = &targetStats{}
+		s.stats[key] = st
+	}
+
+	return st
+}
+
+// observe adds a result to the stats of the given key. The RTT histogram is
+// reset if its layout has been changed.
+func (s *targetStatsSet) observe(key string, layout histogramLayout, reply echoReply, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.statsOf(key)
+
+	st.packetsSent++
+	if err != nil {
+		st.consecutiveLost++
+		st.countError(err)
+		return
+	}
+	st.packetsReceived++
+	st.consecutiveLost = 0
+	st.lastReply = time.Now()
+	st.replyTTL = reply.ttl
+	if reply.mac != nil {
+		st.mac = reply.mac.String()
+	}
+	if reply.iface != nil {
+		st.iface = reply.iface
+	}
+
+	switch reply.payload {
+	case payloadCorrupted:
+		st.corruptedReplies++
+	case payloadTruncated:
+		st.truncatedReplies++
+	}
+
+	if st.rttHistogram == nil || !st.rttHistogramLayout.equal(layout) {
+		st.rttHistogram = newRTTHistogram(layout)
+		st.rttHistogramLayout = layout
+	}
+	st.rttHistogram.Observe(reply.rtt.Seconds())
+}
+
+// countError counts the reason of a lost packet
+func (s *targetStats) countError(err error) {
+	var icmpErr *icmpError
+	var sendErr *sendError
+	switch {
+	case errors.As(err, &icmpErr):
+		if s.icmpErrors == nil {
+			s.icmpErrors = make(map[icmpErrorKey]uint64)
+		}
+		s.icmpErrors[icmpErrorKey{icmpErr.typeLabel(), icmpErr.code, icmpErr.reporter.String()}]++
+	case errors.As(err, &sendErr):
+		s.sendErrors++
+	case errors.Is(err, syscall.ECONNREFUSED):
+		s.connectionsRefused++
+	case isTimeout(err):
+		s.timeouts++
+	}
+}
+
+// isTimeout checks if a probe timed out, TCP probes report the timeout of the dialer
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, errTimeout) || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// observeReplyEvent counts an irregular reply for the given key
+func (s *targetStatsSet) observeReplyEvent(key string, ev replyEvent, delay time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.statsOf(key)
+
+	switch ev {
+	case replyDuplicate:
+		st.duplicateReplies++
+	case replyReordered:
+		st.reorderedReplies++
+	case replyLate:
+		st.lateReplies++
+		if st.lateDelay == nil {
+			st.lateDelay = prometheus.NewHistogram(prometheus.HistogramOpts{
+				Name:    "late_reply_delay_seconds",
+				Buckets: prometheus.ExponentialBuckets(0.01, 2, 10),
+			})
+		}
+		st.lateDelay.Observe(delay.Seconds())
+	}
+}
+
+// observePathMTU records the result of a path MTU discovery for the given key
+func (s *targetStatsSet) observePathMTU(key string, r pathMTUResult) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.statsOf(key)
+
+	if r.err != nil {
+		st.pathMTUFailures++
+		return
+	}
+
+	st.pathMTU = r.mtu
+	if r.blackHole {
+		st.pathMTUBlackHoles++
+	}
+}
+
+// observeBurst counts a finished burst for the given key
+func (s *targetStatsSet) observeBurst(key string, sent, lost int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.statsOf(key)
+
+	st.bursts++
+	switch {
+	case lost == sent:
+		st.burstsLost++
+	case lost > 0:
+		st.burstsPartiallyLost++
+	}
+}
+
+// observeOneWayDelays records the one way delays of the latest ICMP timestamp reply
+func (s *targetStatsSet) observeOneWayDelays(key string, d oneWayDelays) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st := s.statsOf(key)
+
+	st.oneWayDelays = &d
+}
+
+// get returns a copy of the stats of the given key or nil if nothing was observed yet
+func (s *targetStatsSet) get(key string) *targetStats {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	st, found := s.stats[key]
+	if !found {
+		return nil
+	}
+
+	cpy := *st
+	cpy.icmpErrors = maps.Clone(st.icmpErrors)
+	return &cpy
+}
+
+// retain drops the stats of all keys not contained in keys
+func (s *targetStatsSet) retain(keys map[string]bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for key := range s.stats {
+		if !keys[key] {
+			delete(s.stats, key)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestTargetStatsSet_observe(t *testing.T) {
+	s := newTargetStatsSet()
+	layout := histogramLayout{buckets: []float64{0.01, 0.1}}
+
+	s.observe("a", layout, echoReply{rtt: 5 * time.Millisecond, ttl: 60}, nil)
+	s.observe("a", layout, echoReply{}, errors.New("i/o timeout"))
+	s.observe("a", layout, echoReply{rtt: 50 * time.Millisecond, ttl: 57}, nil)
+	s.observe("b", layout, echoReply{}, errors.New("i/o timeout"))
+
+	st := s.get("a")
+	if st == nil {
+		t.Fatal("expected stats for a")
+	}
+	if st.packetsSent != 3 || st.packetsReceived != 2 || st.packetsLost() != 1 {
+		t.Errorf("unexpected counters sent=%d received=%d lost=%d", st.packetsSent, st.packetsReceived, st.packetsLost())
+	}
+	if st.rttHistogram == nil {
+		t.Error("expected histogram for a")
+	}
+	if st.replyTTL != 57 || st.hopCount() != 7 {
+		t.Errorf("expected TTL 57 and 7 hops, got %d and %d", st.replyTTL, st.hopCount())
+	}
+	if !st.up() || st.consecutiveLost != 0 || st.lastReply.IsZero() {
+		t.Errorf("expected a to be up, got up=%v consecutive_lost=%d last_reply=%v", st.up(), st.consecutiveLost, st.lastReply)
+	}
+
+	s.observe("b", layout, echoReply{}, errors.New("i/o timeout"))
+	st = s.get("b")
+	if st == nil || st.packetsLost() != 2 || st.rttHistogram != nil {
+		t.Errorf("unexpected stats for b: %+v", st)
+	}
+	if st.up() || st.consecutiveLost != 2 || !st.lastReply.IsZero() {
+		t.Errorf("expected b to be down, got up=%v consecutive_lost=%d last_reply=%v", st.up(), st.consecutiveLost, st.lastReply)
+	}
+
+	s.retain(map[string]bool{"b": true})
+	if s.get("a") != nil {
+		t.Error("expected stats for a to be dropped")
+	}
+	if s.get("b") == nil {
+		t.Error("expected stats for b to be retained")
+	}
+}
+
+func TestTargetStatsSet_observe_layoutChange(t *testing.T) {
+	s := newTargetStatsSet()
+	before := histogramLayout{buckets: []float64{0.01, 0.1}}
+	after := histogramLayout{buckets: []float64{0.005, 0.05, 0.5}}
+
+	s.observe("a", before, echoReply{rtt: 5 * time.Millisecond}, nil)
+	s.observe("a", before, echoReply{rtt: 50 * time.Millisecond}, nil)
+	s.observe("a", after, echoReply{rtt: 20 * time.Millisecond}, nil)
+
+	var m dto.Metric
+	if err := s.get("a").rttHistogram.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+
+	// the histogram starts over with the buckets of the reloaded config
+	if h := m.GetHistogram(); h.GetSampleCount() != 1 || len(h.Bucket) != 3 || h.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("expected histogram to be reset, got %v", h)
+	}
+}
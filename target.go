@@ -6,12 +6,12 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
 
 	"github.com/czerwonk/ping_exporter/config"
-	mon "github.com/digineo/go-ping/monitor"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -20,10 +20,23 @@ type ipVersion uint8
 
 type target struct {
 	host      string
+	probe     string // probe type, see config.ProbeType
+	port      uint16
+	socket    socketOptions
+	probers   []prober // one per flow, a single one without flows
+	flows     int
+	monitor   *monitor // the monitor of the schedule of the target
+	trace     traceSettings
+	timestamp bool   // send ICMP timestamp requests
+	query     string // interface queried by extended-echo probes
 	addresses []net.IPAddr
 	delay     time.Duration
 	resolver  Resolver
-	mutex     sync.Mutex
+	mutex     sync.Mutex // guards addresses
+
+	// serializes the updates, so a slow lookup does not overwrite the
+	// addresses of a newer one
+	updateMutex sync.Mutex
 }
 
 type targets struct {
@@ -38,21 +51,27 @@ func (t *targets) SetTargets(tar []*target) {
 }
 
 func (t *targets) Contains(tar *target) bool {
+	return t.Get(tar) != nil
+}
+
+// ContainsHost checks if any target (regardless of its probe) has the given host
+func (t *targets) ContainsHost(host string) bool {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 	for _, ta := range t.t {
-		if ta.host == tar.host {
+		if ta.host == host {
 			return true
 		}
 	}
 	return false
 }
 
-func (t *targets) Get(host string) *target {
+// Get returns the target probing the same host the same way as tar
+func (t *targets) Get(tar *target) *target {
 	t.mutex.RLock()
 	defer t.mutex.RUnlock()
 	for _, ta := range t.t {
-		if ta.host == host {
+		if ta.equal(tar) {
 			return ta
 		}
 	}
@@ -78,15 +97,33 @@ const (
 	ipv6 ipVersion = 6
 )
 
-func (t *target) removeFromMonitor(monitor *mon.Monitor) {
+// equal checks if both targets probe the same host the same way
+func (t *target) equal(o *target) bool {
+	return t.host == o.host && t.probe == o.probe && t.port == o.port &&
+		t.socket == o.socket && t.monitor == o.monitor && t.trace == o.trace &&
+		t.timestamp == o.timestamp && t.query == o.query && t.flows == o.flows
+}
+
+func (t *target) removeFromMonitor() {
 	for _, addr := range t.addresses {
-		monitor.RemoveTarget(t.nameForIP(addr))
+		t.removeAddr(addr)
 	}
 }
 
-func (t *target) addOrUpdateMonitor(monitor *mon.Monitor, opts targetOpts, cfg *config.Config) error {
-	t.mutex.Lock()
-	defer t.mutex.Unlock()
+// removeAddr removes the flows of the address from the monitor
+func (t *target) removeAddr(addr net.IPAddr) {
+	var wg sync.WaitGroup
+	for i := range t.probers {
+		wg.Go(func() {
+			t.monitor.RemoveTarget(t.nameForFlow(addr, i))
+		})
+	}
+	wg.Wait()
+}
+
+func (t *target) addOrUpdateMonitor(opts targetOpts, cfg *config.Config) error {
+	t.updateMutex.Lock()
+	defer t.updateMutex.Unlock()
 
 	ctx := context.Background()
 	if cfg.DNS.Timeout.Duration() != time.Duration(0*time.Second) {
@@ -95,8 +132,11 @@ func (t *target) addOrUpdateMonitor(monitor *mon.Monitor, opts targetOpts, cfg *
 		ctx, cancel = context.WithTimeout(context.Background(), cfg.DNS.Timeout.Duration())
 		defer cancel()
 	}
+	start := time.Now()
 	addrs, err := t.resolver.LookupIPAddr(ctx, t.host)
+	dnsLookupDuration.Observe(time.Since(start).Seconds())
 	if err != nil {
+		dnsLookupErrors.WithLabelValues(t.host).Inc()
 		return fmt.Errorf("error resolving target '%s': %w", t.host, err)
 	}
 
@@ -110,49 +150,95 @@ func (t *target) addOrUpdateMonitor(monitor *mon.Monitor, opts targetOpts, cfg *
 			log.Infof("IPv4 disabled: skipping target for host %s (%v)", t.host, addr)
 			continue
 		}
+		if !t.socket.permits(addr) {
+			log.Infof("source address %s: skipping target for host %s (%v)", t.socket.source, t.host, addr)
+			continue
+		}
 		sanitizedAddrs = append(sanitizedAddrs, addr)
 	}
 
+	// the mutex is not held during the lookup, so it does not block reading the addresses
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
 	for _, addr := range sanitizedAddrs {
-		err := t.addIfNew(addr, monitor)
+		err := t.addIfNew(addr)
 		if err != nil {
 			return err
 		}
 	}
 
-	t.cleanUp(sanitizedAddrs, monitor)
+	t.cleanUp(sanitizedAddrs)
 	t.addresses = sanitizedAddrs
 
 	return nil
 }
 
-func (t *target) addIfNew(addr net.IPAddr, monitor *mon.Monitor) error {
+// Addresses returns the currently monitored addresses of the target
+func (t *target) Addresses() []net.IPAddr {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return slices.Clone(t.addresses)
+}
+
+func (t *target) addIfNew(addr net.IPAddr) error {
 	if isIPAddrInSlice(addr, t.addresses) {
 		return nil
 	}
 
-	return t.add(addr, monitor)
+	return t.add(addr)
 }
 
-func (t *target) cleanUp(addr []net.IPAddr, monitor *mon.Monitor) {
+func (t *target) cleanUp(addr []net.IPAddr) {
 	for _, o := range t.addresses {
 		if !isIPAddrInSlice(o, addr) {
-			name := t.nameForIP(o)
-			log.Infof("removing target for host %s (%v)", t.host, o)
-			monitor.RemoveTarget(name)
+			log.Infof("removing %s target for host %s (%v)", t.probe, t.host, o)
+			t.removeAddr(o)
 		}
 	}
 }
 
-func (t *target) add(addr net.IPAddr, monitor *mon.Monitor) error {
-	name := t.nameForIP(addr)
-	log.Infof("adding target for host %s (%v)", t.host, addr)
+func (t *target) add(addr net.IPAddr) error {
+	log.Infof("adding %s target for host %s (%v)", t.probe, t.host, addr)
 
-	return monitor.AddTargetDelayed(name, addr, t.delay)
+	for i, p := range t.probers {
+		opts := probeOptions{
+			prober:    p,
+			pathMTU:   t.socket.df, // the DF flag is set for the path MTU discovery only
+			timestamp: t.timestamp,
+			trace:     t.trace,
+		}
+
+		// the flows are spread over the interval
+		delay := t.delay + t.monitor.interval*time.Duration(i)/time.Duration(len(t.probers))
+		if err := t.monitor.AddTargetDelayed(t.nameForFlow(addr, i), addr, opts, delay); err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
+// nameForIP returns the key of the address in the monitor, the port is part
+// of the key so a changed port does not collide with the previous target
 func (t *target) nameForIP(addr net.IPAddr) string {
-	return fmt.Sprintf("%s %s %s", t.host, addr.IP, getIPVersion(addr))
+	probe := t.probe
+	if t.port != 0 {
+		probe = fmt.Sprintf("%s:%d", t.probe, t.port)
+	}
+
+	return fmt.Sprintf("%s %s %s %s %s %d", t.host, addr.IP, getIPVersion(addr), probe, t.socket.sourceLabel(), t.socket.tos)
+}
+
+// nameForFlow returns the key of a flow of the address in the monitor, the
+// key of the address if flows are disabled
+func (t *target) nameForFlow(addr net.IPAddr, flow int) string {
+	if t.flows == 0 {
+		return t.nameForIP(addr)
+	}
+
+	return fmt.Sprintf("%s %d", t.nameForIP(addr), flow)
 }
 
 func isIPAddrInSlice(ipa net.IPAddr, slice []net.IPAddr) bool {
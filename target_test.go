@@ -8,8 +8,11 @@ import (
 	"os"
 	"sync"
 	"testing"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+
+	"github.com/czerwonk/ping_exporter/config"
 )
 
 var (
@@ -117,27 +120,28 @@ func Test_target_nameForIP(t *testing.T) {
 		{
 			"ipv4-localhost",
 			ipv4Addr[0],
-			"testhost.com 127.0.0.1 4",
+			"testhost.com 127.0.0.1 4 icmp  0",
 		},
 		{
 			"ipv6-localhost",
 			ipv6Addr[0],
-			"testhost.com ::1 6",
+			"testhost.com ::1 6 icmp  0",
 		},
 		{
 			"ipv4-google",
 			ipv4AddrGoogle[0],
-			"testhost.com 142.250.72.206 4",
+			"testhost.com 142.250.72.206 4 icmp  0",
 		},
 		{
 			"ipv6-google",
 			ipv6AddrGoogle[0],
-			"testhost.com 2607:f8b0:4005:810::200e 6",
+			"testhost.com 2607:f8b0:4005:810::200e 6 icmp  0",
 		},
 	}
 	for _, tt := range tests {
 		tr := &target{
 			host:      "testhost.com",
+			probe:     "icmp",
 			addresses: []net.IPAddr{},
 			delay:     0,
 			resolver:  &net.Resolver{},
@@ -150,3 +154,102 @@ func Test_target_nameForIP(t *testing.T) {
 		})
 	}
 }
+
+func Test_target_nameForIP_bound(t *testing.T) {
+	tr := &target{
+		host:   "testhost.com",
+		probe:  "tcp",
+		port:   443,
+		socket: socketOptions{source: "192.0.2.1", device: "eth0", tos: 184},
+	}
+
+	want := "testhost.com 127.0.0.1 4 tcp:443 192.0.2.1%eth0 184"
+	if got := tr.nameForIP(ipv4Addr[0]); got != want {
+		t.Errorf("target.nameForIP() = %v, want %v", got, want)
+	}
+	if l := labelsOfKey(want); l[3] != "tcp" || l[4] != "192.0.2.1%eth0" || l[5] != "46" || l[7] != "443" {
+		t.Errorf("unexpected labels %q", l)
+	}
+}
+
+func Test_target_nameForFlow(t *testing.T) {
+	tr := &target{host: "testhost.com", probe: "icmp", flows: 4}
+
+	want := "testhost.com 127.0.0.1 4 icmp  0 3"
+	if got := tr.nameForFlow(ipv4Addr[0], 3); got != want {
+		t.Errorf("target.nameForFlow() = %v, want %v", got, want)
+	}
+	if l := labelsOfKey(want); len(l) != 8 || l[6] != "3" || l[7] != "" {
+		t.Errorf("unexpected labels %q", l)
+	}
+
+	tr.flows = 0
+	if l := labelsOfKey(tr.nameForFlow(ipv4Addr[0], 0)); len(l) != 8 || l[6] != "" {
+		t.Errorf("unexpected labels without flows %q", l)
+	}
+}
+
+// slowResolver answers the first lookup with the first addresses once
+// released, later ones immediately with the second addresses
+type slowResolver struct {
+	first, second []net.IPAddr
+	entered       chan struct{}
+	release       chan struct{}
+	calls         int
+	mutex         sync.Mutex
+}
+
+func (r *slowResolver) LookupIPAddr(context.Context, string) ([]net.IPAddr, error) {
+	r.mutex.Lock()
+	r.calls++
+	first := r.calls == 1
+	r.mutex.Unlock()
+
+	if !first {
+		return r.second, nil
+	}
+
+	close(r.entered)
+	<-r.release
+	return r.first, nil
+}
+
+func Test_target_addOrUpdateMonitor_serialized(t *testing.T) {
+	r := &slowResolver{
+		first:   []net.IPAddr{{IP: net.ParseIP("192.0.2.1")}},
+		second:  []net.IPAddr{{IP: net.ParseIP("192.0.2.2")}},
+		entered: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	tr := &target{
+		host:     "testhost.com",
+		probe:    "icmp",
+		probers:  []prober{nil},
+		monitor:  newMonitor(time.Hour, time.Second),
+		delay:    time.Hour, // nothing is sent
+		resolver: r,
+	}
+	cfg := &config.Config{}
+
+	var wg sync.WaitGroup
+	wg.Go(func() {
+		tr.addOrUpdateMonitor(targetOpts{}, cfg)
+	})
+	<-r.entered
+	wg.Go(func() {
+		tr.addOrUpdateMonitor(targetOpts{}, cfg)
+	})
+
+	// give the newer update the chance to overtake the slow lookup
+	time.Sleep(50 * time.Millisecond)
+	close(r.release)
+	wg.Wait()
+
+	if addrs := tr.Addresses(); len(addrs) != 1 || !addrs[0].IP.Equal(r.second[0].IP) {
+		t.Errorf("expected the addresses of the newer lookup, got %v", addrs)
+	}
+
+	for _, addr := range tr.Addresses() {
+		tr.removeAddr(addr)
+	}
+}
@@ -0,0 +1,62 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// tcpProber measures the time it takes to establish a TCP connection
+type tcpProber struct {
+	port     uint16
+	socket   socketOptions
+	sequence atomic.Uint32
+}
+
+func newTCPProber(port uint16, b socketOptions) *tcpProber {
+	return &tcpProber{port: port, socket: b}
+}
+
+// Ping implements the prober interface. Since every handshake uses its own
+// connection, there are no late or duplicate responses.
+func (p *tcpProber) Ping(dst *net.IPAddr, timeout time.Duration, _ extraReplyHandler) (echoReply, error) {
+	seq := uint16(p.sequence.Add(1))
+	d := net.Dialer{
+		Timeout: timeout,
+		Control: chainControl(bindToDevice(p.socket.device), markControl(p.socket.mark), trafficClassControl(p.socket.tos)),
+	}
+	if src := p.socket.sourceIP(); src != nil {
+		d.LocalAddr = &net.TCPAddr{IP: src}
+	}
+
+	start := time.Now()
+	conn, err := d.Dial("tcp", net.JoinHostPort(dst.String(), strconv.Itoa(int(p.port))))
+	rtt := time.Since(start)
+	if err != nil {
+		return echoReply{}, dialError(err)
+	}
+
+	if c, ok := conn.(*net.TCPConn); ok {
+		// reset instead of the regular close, so probes do not pile up in TIME_WAIT
+		c.SetLinger(0)
+	}
+	conn.Close()
+
+	return echoReply{seq: seq, rtt: rtt}, nil
+}
+
+// dialError classifies the error of a failed handshake. Unreachable hosts and
+// networks are reported as send errors, the kernel does not tell missing routes
+// from ICMP errors received. Refused connections and timeouts are passed on.
+func dialError(err error) error {
+	if errors.Is(err, syscall.EHOSTUNREACH) || errors.Is(err, syscall.ENETUNREACH) {
+		return &sendError{err}
+	}
+
+	return err
+}
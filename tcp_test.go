@@ -0,0 +1,56 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTCPProber_Ping(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("unable to listen: %v", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	port := uint16(ln.Addr().(*net.TCPAddr).Port)
+	dst := &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+
+	p := newTCPProber(port, socketOptions{})
+	for i := 1; i <= 2; i++ {
+		reply, err := p.Ping(dst, time.Second, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if reply.seq != uint16(i) {
+			t.Errorf("expected seq %d, got %d", i, reply.seq)
+		}
+		if reply.rtt <= 0 || reply.rtt > time.Second {
+			t.Errorf("unexpected rtt %v", reply.rtt)
+		}
+	}
+
+	ln.Close()
+	_, err = p.Ping(dst, time.Second, nil)
+	if err == nil {
+		t.Fatal("expected error for closed port")
+	}
+
+	var st targetStats
+	st.countError(err)
+	if st.connectionsRefused != 1 {
+		t.Errorf("expected refused connection, got %v", err)
+	}
+}
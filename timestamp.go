@@ -0,0 +1,100 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+// icmpTimestamps are the originate, receive and transmit timestamps of an
+// ICMP timestamp message in milliseconds since midnight UT (RFC 792)
+type icmpTimestamps [3]uint32
+
+// timestampNonStandard is set in timestamps not in milliseconds since midnight UT
+const timestampNonStandard = 1 << 31
+
+var errNonStandardTimestamp = errors.New("non-standard timestamp in reply")
+
+// timestampProber sends ICMP timestamp requests
+type timestampProber interface {
+	PingTimestamp(dst *net.IPAddr, timeout time.Duration) (oneWayDelays, error)
+}
+
+// oneWayDelays are estimated from an ICMP timestamp request and its reply.
+// The delays include the offset of the clock of the target, so they are only
+// accurate if both clocks are synchronized.
+type oneWayDelays struct {
+	forward time.Duration // from the exporter to the target
+	back    time.Duration // from the target to the exporter
+	offset  time.Duration // of the clock of the target, assuming a symmetric path (NTP)
+}
+
+// computeOneWayDelays estimates the one way delays of a timestamp request sent
+// and answered at the given times with the timestamps of the reply
+func computeOneWayDelays(sent, received time.Time, ts icmpTimestamps) (oneWayDelays, error) {
+	if ts[1]&timestampNonStandard != 0 || ts[2]&timestampNonStandard != 0 {
+		return oneWayDelays{}, errNonStandardTimestamp
+	}
+
+	remoteReceived := time.Duration(ts[1]) * time.Millisecond
+	remoteSent := time.Duration(ts[2]) * time.Millisecond
+
+	d := oneWayDelays{
+		forward: sinceMidnight(remoteReceived - timeOfDay(sent)),
+		back:    sinceMidnight(timeOfDay(received) - remoteSent),
+	}
+	d.offset = (d.forward - d.back) / 2
+
+	return d, nil
+}
+
+// timeOfDay returns the time elapsed since midnight UT
+func timeOfDay(t time.Time) time.Duration {
+	return t.Sub(t.UTC().Truncate(24 * time.Hour))
+}
+
+// sinceMidnight maps a difference of times of day to the range of +-12
+// hours, so differences across midnight are correct
+func sinceMidnight(d time.Duration) time.Duration {
+	const day = 24 * time.Hour
+
+	d %= day
+	switch {
+	case d > day/2:
+		d -= day
+	case d <= -day/2:
+		d += day
+	}
+
+	return d
+}
+
+// marshalTimestamps writes the identifier, sequence number and timestamps of
+// an ICMP timestamp message body to a new slice
+func marshalTimestamps(id, seq uint16, ts icmpTimestamps) []byte {
+	b := make([]byte, 16)
+	binary.BigEndian.PutUint16(b[0:], id)
+	binary.BigEndian.PutUint16(b[2:], seq)
+	for i, t := range ts {
+		binary.BigEndian.PutUint32(b[4+4*i:], t)
+	}
+
+	return b
+}
+
+// parseTimestamps returns identifier, sequence number and timestamps of an
+// ICMP timestamp message body
+func parseTimestamps(b []byte) (id, seq uint16, ts icmpTimestamps, ok bool) {
+	if len(b) < 16 {
+		return 0, 0, ts, false
+	}
+
+	for i := range ts {
+		ts[i] = binary.BigEndian.Uint32(b[4+4*i:])
+	}
+
+	return binary.BigEndian.Uint16(b[0:]), binary.BigEndian.Uint16(b[2:]), ts, true
+}
@@ -0,0 +1,59 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestComputeOneWayDelays(t *testing.T) {
+	midnight := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name     string
+		sent     time.Time
+		ts       icmpTimestamps
+		received time.Time
+		want     oneWayDelays
+	}{
+		{
+			name:     "synchronized clocks",
+			sent:     midnight.Add(time.Hour),
+			ts:       icmpTimestamps{0, 3600_010, 3600_011},
+			received: midnight.Add(time.Hour + 41*time.Millisecond),
+			want:     oneWayDelays{forward: 10 * time.Millisecond, back: 30 * time.Millisecond, offset: -10 * time.Millisecond},
+		},
+		{
+			name:     "across midnight",
+			sent:     midnight.Add(-5 * time.Millisecond),
+			ts:       icmpTimestamps{0, 5, 6},
+			received: midnight.Add(16 * time.Millisecond),
+			want:     oneWayDelays{forward: 10 * time.Millisecond, back: 10 * time.Millisecond},
+		},
+		{
+			name:     "clock behind",
+			sent:     midnight.Add(time.Hour),
+			ts:       icmpTimestamps{0, 3599_910, 3599_910},
+			received: midnight.Add(time.Hour + 20*time.Millisecond),
+			want:     oneWayDelays{forward: -90 * time.Millisecond, back: 110 * time.Millisecond, offset: -100 * time.Millisecond},
+		},
+	} {
+		got, err := computeOneWayDelays(tt.sent, tt.received, tt.ts)
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %+v (%v), want %+v", tt.name, got, err, tt.want)
+		}
+	}
+
+	if _, err := computeOneWayDelays(midnight, midnight, icmpTimestamps{0, timestampNonStandard | 1, 1}); err == nil {
+		t.Error("expected non-standard timestamp to be rejected")
+	}
+}
+
+func TestParseTimestamps(t *testing.T) {
+	ts := icmpTimestamps{1, 2, 3}
+	id, seq, got, ok := parseTimestamps(marshalTimestamps(7, 42, ts))
+	if !ok || id != 7 || seq != 42 || got != ts {
+		t.Errorf("got id %d, seq %d, timestamps %v (%v)", id, seq, got, ok)
+	}
+}
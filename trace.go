@@ -0,0 +1,183 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// traceSettings limit the traces of a target, tracing is disabled if maxHops is 0
+type traceSettings struct {
+	maxHops      int // highest TTL probed
+	maxAddresses int // number of addresses per hop exported
+}
+
+// ttlProber sends probes with limited TTL, routers on the path answer with
+// ICMP time exceeded
+type ttlProber interface {
+	PingTTL(dst *net.IPAddr, ttl int, timeout time.Duration) (echoReply, error)
+}
+
+// hopResult is the outcome of a single probe of a hop
+type hopResult struct {
+	addr string // responder, empty if lost
+	rtt  time.Duration
+}
+
+// hopMetrics are computed from the last results of a hop. The RTTs are in
+// seconds, by responder (most recent first).
+type hopMetrics struct {
+	Hop       int
+	Address   string // latest responder, empty if none
+	LossRatio float64
+	RTTs      []hopRTT
+}
+
+// hopRTT is the mean RTT of a responder of a hop
+type hopRTT struct {
+	Address string
+	Mean    float64
+}
+
+// tracer probes the hops to a target in rounds like mtr, sending a TTL limited
+// probe per hop and keeping the last results of each hop
+type tracer struct {
+	prober   ttlProber
+	settings traceSettings
+	capacity int // results kept per hop
+
+	hops  [][]hopResult // by TTL, the oldest result first
+	limit int           // highest TTL of the next round
+	mutex sync.Mutex
+
+	running sync.Mutex
+}
+
+func newTracer(p ttlProber, settings traceSettings, capacity int) *tracer {
+	return &tracer{
+		prober:   p,
+		settings: settings,
+		capacity: capacity,
+		limit:    settings.maxHops,
+	}
+}
+
+// round probes the hops to dst, it returns immediately if the previous round
+// is still waiting for replies
+func (t *tracer) round(dst *net.IPAddr, timeout time.Duration) {
+	if !t.running.TryLock() {
+		return
+	}
+	defer t.running.Unlock()
+
+	t.mutex.Lock()
+	limit := t.limit
+	t.mutex.Unlock()
+
+	results := make([]hopResult, limit)
+	reached := make([]bool, limit)
+	var wg sync.WaitGroup
+	for i := range limit {
+		wg.Go(func() {
+			reply, err := t.prober.PingTTL(dst, i+1, timeout)
+			if err != nil {
+				return
+			}
+
+			results[i] = hopResult{addr: dst.IP.String(), rtt: reply.rtt}
+			if reply.hop != nil {
+				results[i].addr = reply.hop.String()
+			} else {
+				reached[i] = true
+			}
+		})
+	}
+	wg.Wait()
+
+	t.add(results, reached)
+}
+
+// add records the results of a round. The hops beyond the first answered by
+// the destination are dropped. If the destination has not been reached, the
+// hops beyond the first silent one after the last answered are dropped.
+func (t *tracer) add(results []hopResult, reached []bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var hops int
+	if i := slices.Index(reached, true); i >= 0 {
+		hops = i + 1
+
+		// a longer path is detected by the next round
+		t.limit = min(hops+1, t.settings.maxHops)
+	} else {
+		last := -1
+		for i, r := range results {
+			if r.addr != "" {
+				last = i
+			}
+		}
+		hops = min(last+2, len(results))
+		t.limit = t.settings.maxHops
+	}
+
+	if len(t.hops) > hops {
+		t.hops = t.hops[:hops]
+	}
+	for len(t.hops) < hops {
+		t.hops = append(t.hops, nil)
+	}
+
+	for i, r := range results[:hops] {
+		h := append(t.hops[i], r)
+		if len(h) > t.capacity {
+			h = h[len(h)-t.capacity:]
+		}
+		t.hops[i] = h
+	}
+}
+
+// compute returns the metrics of each hop
+func (t *tracer) compute() []hopMetrics {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	ret := make([]hopMetrics, 0, len(t.hops))
+	for i, results := range t.hops {
+		m := hopMetrics{Hop: i + 1}
+
+		sums := make(map[string]time.Duration)
+		counts := make(map[string]int)
+		lost := 0
+		for j := len(results) - 1; j >= 0; j-- {
+			r := results[j]
+			if r.addr == "" {
+				lost++
+				continue
+			}
+
+			if m.Address == "" {
+				m.Address = r.addr
+			}
+			if _, found := counts[r.addr]; !found && len(m.RTTs) < t.settings.maxAddresses {
+				m.RTTs = append(m.RTTs, hopRTT{Address: r.addr})
+			}
+			sums[r.addr] += r.rtt
+			counts[r.addr]++
+		}
+
+		for j, rtt := range m.RTTs {
+			m.RTTs[j].Mean = (sums[rtt.Address] / time.Duration(counts[rtt.Address])).Seconds()
+		}
+		if len(results) > 0 {
+			m.LossRatio = float64(lost) / float64(len(results))
+		}
+
+		ret = append(ret, m)
+	}
+
+	return ret
+}
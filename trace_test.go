@@ -0,0 +1,82 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// traceFake answers TTL limited requests like a path of routers
+type traceFake struct {
+	path []string // responders by TTL, the last one is the destination
+	lost int      // TTL not answered, 0 if none
+}
+
+func (f *traceFake) PingTTL(dst *net.IPAddr, ttl int, _ time.Duration) (echoReply, error) {
+	switch {
+	case ttl == f.lost:
+		return echoReply{}, errTimeout
+	case ttl >= len(f.path):
+		return echoReply{rtt: time.Duration(len(f.path)) * time.Millisecond}, nil
+	}
+
+	return echoReply{rtt: time.Duration(ttl) * time.Millisecond, hop: net.ParseIP(f.path[ttl-1])}, nil
+}
+
+func TestTracer(t *testing.T) {
+	dst := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	fake := &traceFake{path: []string{"198.51.100.1", "198.51.100.2", "192.0.2.1"}, lost: 2}
+	tr := newTracer(fake, traceSettings{maxHops: 30, maxAddresses: 2}, 4)
+
+	tr.round(dst, time.Second)
+	if tr.limit != 4 {
+		t.Errorf("expected the next round to probe 4 hops, got %d", tr.limit)
+	}
+
+	fake.lost = 0
+	fake.path[0] = "198.51.100.3"
+	tr.round(dst, time.Second)
+
+	hops := tr.compute()
+	if len(hops) != 3 {
+		t.Fatalf("expected 3 hops, got %d", len(hops))
+	}
+
+	if h := hops[0]; h.Address != "198.51.100.3" || h.LossRatio != 0 || len(h.RTTs) != 2 {
+		t.Errorf("unexpected metrics of hop 1: %+v", h)
+	}
+	if h := hops[1]; h.Address != "198.51.100.2" || h.LossRatio != 0.5 || len(h.RTTs) != 1 || h.RTTs[0].Mean != 0.002 {
+		t.Errorf("unexpected metrics of hop 2: %+v", h)
+	}
+	if h := hops[2]; h.Address != "192.0.2.1" || h.LossRatio != 0 {
+		t.Errorf("unexpected metrics of hop 3: %+v", h)
+	}
+}
+
+func TestTracer_unreachable(t *testing.T) {
+	dst := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	fake := &traceFake{path: []string{"198.51.100.1", "198.51.100.2"}}
+	tr := newTracer(&unreachableFake{fake}, traceSettings{maxHops: 10, maxAddresses: 1}, 4)
+
+	tr.round(dst, time.Second)
+
+	// the first silent hop after the last answered one is kept
+	if hops := tr.compute(); len(hops) != 3 || hops[2].LossRatio != 1 {
+		t.Errorf("expected 3 hops with the last one lost, got %+v", hops)
+	}
+}
+
+// unreachableFake drops the requests beyond the routers of the path
+type unreachableFake struct {
+	*traceFake
+}
+
+func (f *unreachableFake) PingTTL(dst *net.IPAddr, ttl int, timeout time.Duration) (echoReply, error) {
+	if ttl > len(f.path) {
+		return echoReply{}, errTimeout
+	}
+
+	return echoReply{rtt: time.Millisecond, hop: net.ParseIP(f.path[ttl-1])}, nil
+}
@@ -0,0 +1,73 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// TWAMP-Light test packets in unauthenticated mode (RFC 5357, section 4)
+const (
+	twampRequestLen = 14 // sequence number, timestamp and error estimate
+	twampReplyLen   = 41 // up to the sender TTL
+
+	// error estimate with clock not synchronized to UTC, scale 0 and multiplier 1
+	twampErrorEstimate = 0x0001
+)
+
+// seconds between the NTP epoch (1900) and the unix epoch
+const ntpEpochOffset = 2208988800
+
+// toNTPTime converts t to the 64 bit NTP timestamp format
+func toNTPTime(t time.Time) uint64 {
+	secs := uint64(t.Unix() + ntpEpochOffset)
+	frac := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return secs<<32 | frac
+}
+
+// fromNTPTime converts a 64 bit NTP timestamp
+func fromNTPTime(ts uint64) time.Time {
+	secs := int64(ts>>32) - ntpEpochOffset
+	nsec := (ts & 0xffffffff) * uint64(time.Second) >> 32
+	return time.Unix(secs, int64(nsec))
+}
+
+// marshalTWAMPRequest writes the header of a sender test packet to b, which
+// needs to be at least twampRequestLen bytes long
+func marshalTWAMPRequest(b []byte, seq uint32, sent time.Time) {
+	binary.BigEndian.PutUint32(b[0:], seq)
+	binary.BigEndian.PutUint64(b[4:], toNTPTime(sent))
+	binary.BigEndian.PutUint16(b[12:], twampErrorEstimate)
+}
+
+// marshalTWAMPReply builds the reflector test packet answering req (at least
+// twampRequestLen bytes long). The reflector uses the sequence number of the
+// sender (stateless reflector) and keeps the packet size symmetric as far as
+// possible, requests shorter than twampReplyLen get a longer reply.
+func marshalTWAMPReply(req []byte, received, sent time.Time, ttl int) []byte {
+	b := make([]byte, max(twampReplyLen, len(req)))
+	copy(b[twampReplyLen:], req[twampRequestLen:]) // the padding is shortened by the larger header
+
+	copy(b[0:4], req[0:4])
+	binary.BigEndian.PutUint64(b[4:], toNTPTime(sent))
+	binary.BigEndian.PutUint16(b[12:], twampErrorEstimate)
+	binary.BigEndian.PutUint64(b[16:], toNTPTime(received))
+	copy(b[24:38], req[0:14]) // sender sequence number, timestamp and error estimate
+	b[40] = byte(ttl)
+
+	return b
+}
+
+// parseTWAMPReply returns the sender sequence number and the time the packet
+// spent in the reflector
+func parseTWAMPReply(b []byte) (seq uint32, processing time.Duration, ok bool) {
+	if len(b) < twampReplyLen {
+		return 0, 0, false
+	}
+
+	sent := fromNTPTime(binary.BigEndian.Uint64(b[4:]))
+	received := fromNTPTime(binary.BigEndian.Uint64(b[16:]))
+
+	return binary.BigEndian.Uint32(b[24:]), sent.Sub(received), true
+}
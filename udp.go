@@ -0,0 +1,264 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+// default destination ports of the UDP probes
+const (
+	udpEchoPort   = 7   // RFC 862
+	twampTestPort = 862 // RFC 8545
+)
+
+var errProberClosed = errors.New("prober closed")
+
+// udpProber sends UDP echo (RFC 862) or TWAMP-Light (RFC 5357) test packets
+// and correlates the replies by sequence number
+type udpProber struct {
+	mode    string // config.ProbeUDPEcho or config.ProbeTWAMPLight
+	port    uint16
+	payload []byte
+
+	conn4  net.PacketConn
+	conn6  net.PacketConn
+	write4 sync.Mutex
+	write6 sync.Mutex
+
+	requests *requestTable
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// newUDPProber opens a socket per IP version (or for the source address of
+// the socket options) on an ephemeral port and starts receiving. size is the length
+// of the test packets.
+func newUDPProber(mode string, port, size uint16, b socketOptions) (*udpProber, error) {
+	if port == 0 {
+		port = udpEchoPort
+		if mode == config.ProbeTWAMPLight {
+			port = twampTestPort
+		}
+	}
+
+	minSize := uint16(4) // sequence number
+	if mode == config.ProbeTWAMPLight {
+		minSize = twampReplyLen // so the reply can be as long as the request
+	}
+
+	p := &udpProber{
+		mode:     mode,
+		port:     port,
+		payload:  make([]byte, max(size, minSize)),
+		requests: newRequestTable(),
+		stop:     make(chan struct{}),
+	}
+	fillPayload(p.payload, b.pattern)
+
+	lc := net.ListenConfig{Control: chainControl(bindToDevice(b.device), markControl(b.mark))}
+	ctx := context.Background()
+	var err error
+	switch src := b.sourceIP(); {
+	case src == nil:
+		// an IP version might not be available on the host
+		p.conn4, _ = lc.ListenPacket(ctx, "udp4", "0.0.0.0:0")
+		p.conn6, err = lc.ListenPacket(ctx, "udp6", "[::]:0")
+	case src.To4() != nil:
+		p.conn4, err = lc.ListenPacket(ctx, "udp4", net.JoinHostPort(b.source, "0"))
+	default:
+		p.conn6, err = lc.ListenPacket(ctx, "udp6", net.JoinHostPort(b.source, "0"))
+	}
+	if p.conn4 == nil && p.conn6 == nil {
+		return nil, fmt.Errorf("unable to open UDP socket for %s probes: %w", mode, err)
+	}
+
+	if b.tos != 0 {
+		if err := setTrafficClass(p.conn4, p.conn6, b.tos); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to set traffic class: %w", err)
+		}
+	}
+
+	for _, c := range []struct {
+		conn net.PacketConn
+		v6   bool
+	}{{p.conn4, false}, {p.conn6, true}} {
+		if c.conn == nil {
+			continue
+		}
+
+		// time exceeded messages of routers are needed for traces
+		if err := enableRecvErr(c.conn, c.v6); err != nil {
+			p.Close()
+			return nil, fmt.Errorf("failed to enable receiving ICMP errors: %w", err)
+		}
+
+		read := newTTLReader(c.conn, c.v6)
+		p.wg.Go(func() {
+			p.receive(c.conn, read)
+		})
+	}
+	p.wg.Go(func() {
+		p.requests.expire(p.stop)
+	})
+
+	return p, nil
+}
+
+// Close closes the sockets and waits for the receivers to stop
+func (p *udpProber) Close() error {
+	close(p.stop)
+	for _, c := range []net.PacketConn{p.conn4, p.conn6} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	p.wg.Wait()
+
+	return nil
+}
+
+// Ping implements the prober interface
+func (p *udpProber) Ping(dst *net.IPAddr, timeout time.Duration, h extraReplyHandler) (echoReply, error) {
+	req, err := p.send(dst, 0, h)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// PingTTL sends a test packet with the given TTL (IPv4) or hop limit (IPv6)
+// to dst and waits for the reply of the destination or the time exceeded
+// message of a router until the timeout expires
+func (p *udpProber) PingTTL(dst *net.IPAddr, ttl int, timeout time.Duration) (echoReply, error) {
+	req, err := p.send(dst, ttl, nil)
+	if err != nil {
+		return echoReply{}, err
+	}
+
+	return p.requests.wait(req, timeout)
+}
+
+// send sends a test packet, ttl is the TTL (IPv4) or hop limit (IPv6) of the
+// packet, 0 for the default of the socket
+func (p *udpProber) send(dst *net.IPAddr, ttl int, h extraReplyHandler) (*echoRequest, error) {
+	conn, lock := p.conn4, &p.write4
+	if dst.IP.To4() == nil {
+		conn, lock = p.conn6, &p.write6
+	}
+	if conn == nil {
+		return nil, fmt.Errorf("no socket for address %s", dst.IP)
+	}
+
+	b := make([]byte, len(p.payload))
+	copy(b, p.payload)
+
+	// TWAMP-Light replies do not echo the payload
+	var payload []byte
+	if p.mode == config.ProbeUDPEcho {
+		payload = b
+	}
+	req, err := p.requests.add(dst.IP, payload, ttl, h)
+	if err != nil {
+		return nil, &sendError{err}
+	}
+	seq := uint32(req.seq)
+	binary.BigEndian.PutUint32(b, seq)
+
+	lock.Lock()
+	p.requests.markSent(req)
+	if p.mode == config.ProbeTWAMPLight {
+		marshalTWAMPRequest(b, seq, req.sent)
+	}
+	addr := &net.UDPAddr{IP: dst.IP, Port: int(p.port), Zone: dst.Zone}
+	err = writeWithTTL(conn, b, addr, ttl)
+	if err != nil && readErrQueue(conn, p.handleICMPError) == nil {
+		// the write reported a received ICMP error message instead
+		err = writeWithTTL(conn, b, addr, ttl)
+	}
+	lock.Unlock()
+
+	if err != nil {
+		p.requests.remove(req.seq)
+		return nil, &sendError{err}
+	}
+
+	return req, nil
+}
+
+// receive reads from the socket until it gets closed
+func (p *udpProber) receive(conn net.PacketConn, read readFunc) {
+	b := make([]byte, 65536)
+
+	for {
+		n, ttl, addr, err := read(b)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				continue
+			}
+
+			// a received ICMP error message is reported by the next read
+			if errors.Is(err, net.ErrClosed) || readErrQueue(conn, p.handleICMPError) != nil {
+				break // socket gone
+			}
+			continue
+		}
+
+		received := time.Now()
+		src, ok := addr.(*net.UDPAddr)
+		if !ok || src.Port != int(p.port) {
+			continue
+		}
+
+		p.handleReply(b[:n], src.IP, ttl, received)
+	}
+
+	p.requests.failAll(errProberClosed)
+}
+
+// handleICMPError passes an ICMP error message referring to a test packet to its request
+func (p *udpProber) handleICMPError(payload []byte, e *icmpError) {
+	if len(payload) < 4 {
+		return
+	}
+
+	seq := uint16(binary.BigEndian.Uint32(payload))
+	if errors.Is(e, errTTLExceeded) {
+		p.requests.handleTimeExceeded(seq, e, time.Now())
+		return
+	}
+
+	p.requests.fail(seq, e)
+}
+
+func (p *udpProber) handleReply(b []byte, src net.IP, ttl int, received time.Time) {
+	if p.mode == config.ProbeTWAMPLight {
+		seq, processing, ok := parseTWAMPReply(b)
+		if !ok {
+			return
+		}
+
+		// the time spent in the reflector is not part of the round trip time
+		if processing > 0 {
+			received = received.Add(-processing)
+		}
+		p.requests.handleReply(uint16(seq), src, ttl, nil, received)
+		return
+	}
+
+	if len(b) < 4 {
+		return
+	}
+	p.requests.handleReply(uint16(binary.BigEndian.Uint32(b)), src, ttl, b, received)
+}
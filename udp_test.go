@@ -0,0 +1,125 @@
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/czerwonk/ping_exporter/config"
+)
+
+func TestUDPProber_Ping(t *testing.T) {
+	for _, mode := range []string{config.ProbeUDPEcho, config.ProbeTWAMPLight} {
+		t.Run(mode, func(t *testing.T) {
+			c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("unable to listen: %v", err)
+			}
+			defer c.Close()
+			go reflect(c, mode, peerFilter{netip.MustParsePrefix("127.0.0.0/8")})
+
+			p, err := newUDPProber(mode, uint16(c.LocalAddr().(*net.UDPAddr).Port), 56, socketOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer p.Close()
+
+			dst := &net.IPAddr{IP: net.ParseIP("127.0.0.1")}
+			for i := 1; i <= 3; i++ {
+				reply, err := p.Ping(dst, time.Second, nil)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if reply.seq != uint16(i) {
+					t.Errorf("expected seq %d, got %d", i, reply.seq)
+				}
+				if reply.rtt <= 0 || reply.rtt > time.Second {
+					t.Errorf("unexpected rtt %v", reply.rtt)
+				}
+			}
+		})
+	}
+}
+
+func TestTWAMPReply(t *testing.T) {
+	sent := time.Unix(1700000000, 123456789)
+	received := sent.Add(10 * time.Millisecond)
+
+	req := make([]byte, 60)
+	marshalTWAMPRequest(req, 42, sent)
+	reply := marshalTWAMPReply(req, received, received.Add(250*time.Microsecond), 57)
+
+	if len(reply) != len(req) {
+		t.Errorf("expected reply of %d bytes, got %d", len(req), len(reply))
+	}
+	if reply[40] != 57 {
+		t.Errorf("expected sender TTL 57, got %d", reply[40])
+	}
+
+	seq, processing, ok := parseTWAMPReply(reply)
+	if !ok || seq != 42 {
+		t.Fatalf("expected reply to seq 42, got %d (ok=%v)", seq, ok)
+	}
+	if d := processing - 250*time.Microsecond; d < -time.Nanosecond || d > time.Nanosecond {
+		t.Errorf("expected processing time of 250µs, got %v", processing)
+	}
+
+	if got := fromNTPTime(toNTPTime(sent)); got.Sub(sent).Abs() > time.Nanosecond {
+		t.Errorf("expected %v after NTP conversion, got %v", sent, got)
+	}
+}
+
+func TestReflect_drops(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		peers  string
+		mode   string
+		size   int
+		answer bool
+	}{
+		{"allowed echo", "127.0.0.0/8", config.ProbeUDPEcho, 14, true},
+		{"peer not allowed", "192.0.2.0/24", config.ProbeUDPEcho, 14, false},
+		{"short twamp request", "127.0.0.0/8", config.ProbeTWAMPLight, twampRequestLen, false},
+		{"twamp request", "127.0.0.0/8", config.ProbeTWAMPLight, twampReplyLen, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Skipf("unable to listen: %v", err)
+			}
+			defer c.Close()
+			peers, err := parsePeerFilter([]string{tt.peers})
+			if err != nil {
+				t.Fatal(err)
+			}
+			go reflect(c, tt.mode, peers)
+
+			client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer client.Close()
+
+			if _, err := client.WriteTo(make([]byte, tt.size), c.LocalAddr()); err != nil {
+				t.Fatal(err)
+			}
+			client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			n, _, err := client.ReadFrom(make([]byte, 1500))
+			switch {
+			case tt.answer && err != nil:
+				t.Errorf("expected answer, got %v", err)
+			case tt.answer && n > tt.size:
+				t.Errorf("expected answer of at most %d bytes, got %d", tt.size, n)
+			case !tt.answer && err == nil:
+				t.Error("expected packet to be dropped")
+			}
+		})
+	}
+
+	if _, err := parsePeerFilter(nil); err == nil {
+		t.Error("expected error without allowed peers")
+	}
+}